@@ -1,4 +1,4 @@
-package main
+package lox
 
 import (
 	"fmt"
@@ -12,22 +12,22 @@ func (a AstPrinter) print(expr Expr) string {
 	return expr.Accept(a).(string)
 }
 
-func (a AstPrinter) VisitBinaryExpr(expr BinaryExpr) any {
+func (a AstPrinter) VisitBinaryExpr(expr *BinaryExpr) any {
 	return a.Parenthesize(expr.Operator.Lexeme, expr.Left, expr.Right)
 }
 
-func (a AstPrinter) VisitGroupingExpr(expr GroupingExpr) any {
+func (a AstPrinter) VisitGroupingExpr(expr *GroupingExpr) any {
 	return a.Parenthesize("group", expr.Expression)
 }
 
-func (a AstPrinter) VisitLiteralExpr(expr LiteralExpr) any {
+func (a AstPrinter) VisitLiteralExpr(expr *LiteralExpr) any {
 	if expr.Value == nil {
 		return "nil"
 	}
 	return fmt.Sprint(expr.Value)
 }
 
-func (a AstPrinter) VisitUnaryExpr(expr UnaryExpr) any {
+func (a AstPrinter) VisitUnaryExpr(expr *UnaryExpr) any {
 	return a.Parenthesize(expr.Operator.Lexeme, expr.Right)
 }
 
@@ -43,7 +43,7 @@ func (a AstPrinter) Parenthesize(name string, exprs ...Expr) string {
 	return str
 }
 
-func (a AstPrinter) VisitVariableExpr(expr VariableExpr) any {
+func (a AstPrinter) VisitVariableExpr(expr *VariableExpr) any {
 	str := "var "
 	str += expr.Name.Lexeme
 	str += " = "
@@ -51,7 +51,7 @@ func (a AstPrinter) VisitVariableExpr(expr VariableExpr) any {
 	return str
 }
 
-//func (a AstPrinter) VisitAssignExpr(expr AssignExpr) any {
+//func (a AstPrinter) VisitAssignExpr(expr *AssignExpr) any {
 //	str := expr.Name.Lexeme
 //	str += " = "
 //	str += expr.Name.value
@@ -0,0 +1,124 @@
+package lox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// builtinType is a built-in type with methods, which are looked up like the
+// properties of an instance. Both backends share its values.
+type builtinType interface {
+	method(name string, token Token) (*builtinMethod, error)
+}
+
+// builtinMethod is a method of a built-in type such as list, bound to its
+// receiver. Unlike a native function it may have optional parameters and
+// call back into Lox.
+type builtinMethod struct {
+	class    string
+	name     string
+	minArity int
+	maxArity int
+	fn       func(call builtinCall) (Value, error)
+}
+
+// builtinCall is what a builtinMethod is called with. callback calls a Lox
+// value the way a call expression at paren would, for methods like map.
+type builtinCall struct {
+	arguments []Value
+	paren     Token
+	callback  func(callee Value, arguments []Value) (Value, error)
+}
+
+func (b *builtinMethod) String() string {
+	return "<native fn>"
+}
+
+func (b *builtinMethod) checkArity(count int, paren Token) error {
+	if count >= b.minArity && count <= b.maxArity {
+		return nil
+	}
+	expected := strconv.Itoa(b.minArity)
+	if b.maxArity != b.minArity {
+		expected += " to " + strconv.Itoa(b.maxArity)
+	}
+	return RuntimeError{paren, fmt.Sprintf("Expected %s arguments but got %d.", expected, count), nil, Value{}}
+}
+
+// getIndex is object[index].
+func getIndex(object Value, index Value, bracket Token) (Value, error) {
+	switch object := object.object.(type) {
+	case *LoxList:
+		return object.get(index, bracket)
+	case *LoxMap:
+		return object.get(index, bracket)
+	}
+	return Value{}, RuntimeError{bracket, "Only lists and maps can be indexed.", nil, Value{}}
+}
+
+// setIndex is object[index] = value.
+func setIndex(object Value, index Value, value Value, bracket Token) error {
+	switch object := object.object.(type) {
+	case *LoxList:
+		return object.set(index, value, bracket)
+	case *LoxMap:
+		return object.set(index, value, bracket)
+	}
+	return RuntimeError{bracket, "Only lists and maps can be indexed.", nil, Value{}}
+}
+
+// writeElement writes value the way it is shown inside a list or map. Strings
+// are quoted so ["a, b"] can't be mistaken for two elements, and a list or map
+// that contains itself is shown as [...] or {...} instead of recursing
+// forever. seen holds the lists and maps being written.
+func writeElement(b *strings.Builder, value Value, seen map[any]bool) {
+	switch value.kind {
+	case StringKind:
+		b.WriteString(strconv.Quote(value.str))
+		return
+	case ListKind, MapKind:
+	default:
+		b.WriteString(value.String())
+		return
+	}
+	if seen[value.object] {
+		if value.kind == ListKind {
+			b.WriteString("[...]")
+		} else {
+			b.WriteString("{...}")
+		}
+		return
+	}
+	seen[value.object] = true
+	switch object := value.object.(type) {
+	case *LoxList:
+		b.WriteByte('[')
+		for k, element := range object.elements {
+			if k > 0 {
+				b.WriteString(", ")
+			}
+			writeElement(b, element, seen)
+		}
+		b.WriteByte(']')
+	case *LoxMap:
+		b.WriteByte('{')
+		for k, entry := range object.entries {
+			if k > 0 {
+				b.WriteString(", ")
+			}
+			writeElement(b, entry.key, seen)
+			b.WriteString(": ")
+			writeElement(b, entry.value, seen)
+		}
+		b.WriteByte('}')
+	}
+	delete(seen, value.object)
+}
+
+// elementString is value the way it is shown inside a list or map.
+func elementString(value Value) string {
+	var b strings.Builder
+	writeElement(&b, value, make(map[any]bool))
+	return b.String()
+}
@@ -0,0 +1,228 @@
+package lox
+
+// opCode is a single bytecode instruction. Operands follow the opcode in the
+// chunk, constant, slot, upvalue and jump operands are two bytes big endian
+// and argument counts are one byte.
+type opCode byte
+
+const (
+	opConstant opCode = iota
+	opNil
+	opTrue
+	opFalse
+	opPop
+	opGetLocal
+	opSetLocal
+	opGetGlobal
+	opDefineGlobal
+	opSetGlobal
+	opGetUpvalue
+	opSetUpvalue
+	opGetProperty
+	opSetProperty
+	opGetSuper
+	opEqual
+	opGreater
+	opGreaterEqual
+	opLess
+	opLessEqual
+	opAdd
+	opSubtract
+	opMultiply
+	opDivide
+	opNot
+	opNegate
+	opPrint
+	opJump
+	opJumpIfFalse
+	opLoop
+	opCall
+	opInvoke
+	opSuperInvoke
+	opClosure
+	opCloseUpvalue
+	opReturn
+	opClass
+	opInherit
+	opMethod
+	opTry
+	opEndTry
+	opCatch
+	opThrow
+	opRethrow
+	opBuildList
+	opBuildMap
+	opGetIndex
+	opSetIndex
+	opStringify
+	opModulo
+	opPower
+	opBitAnd
+	opBitOr
+	opBitXor
+	opShiftLeft
+	opShiftRight
+	opDup
+	opDup2
+	opRotate
+	opStaticMethod
+	opNop
+)
+
+func (o opCode) String() string {
+	switch o {
+	case opConstant:
+		return "OP_CONSTANT"
+	case opNil:
+		return "OP_NIL"
+	case opTrue:
+		return "OP_TRUE"
+	case opFalse:
+		return "OP_FALSE"
+	case opPop:
+		return "OP_POP"
+	case opGetLocal:
+		return "OP_GET_LOCAL"
+	case opSetLocal:
+		return "OP_SET_LOCAL"
+	case opGetGlobal:
+		return "OP_GET_GLOBAL"
+	case opDefineGlobal:
+		return "OP_DEFINE_GLOBAL"
+	case opSetGlobal:
+		return "OP_SET_GLOBAL"
+	case opGetUpvalue:
+		return "OP_GET_UPVALUE"
+	case opSetUpvalue:
+		return "OP_SET_UPVALUE"
+	case opGetProperty:
+		return "OP_GET_PROPERTY"
+	case opSetProperty:
+		return "OP_SET_PROPERTY"
+	case opGetSuper:
+		return "OP_GET_SUPER"
+	case opEqual:
+		return "OP_EQUAL"
+	case opGreater:
+		return "OP_GREATER"
+	case opGreaterEqual:
+		return "OP_GREATER_EQUAL"
+	case opLess:
+		return "OP_LESS"
+	case opLessEqual:
+		return "OP_LESS_EQUAL"
+	case opAdd:
+		return "OP_ADD"
+	case opSubtract:
+		return "OP_SUBTRACT"
+	case opMultiply:
+		return "OP_MULTIPLY"
+	case opDivide:
+		return "OP_DIVIDE"
+	case opNot:
+		return "OP_NOT"
+	case opNegate:
+		return "OP_NEGATE"
+	case opPrint:
+		return "OP_PRINT"
+	case opJump:
+		return "OP_JUMP"
+	case opJumpIfFalse:
+		return "OP_JUMP_IF_FALSE"
+	case opLoop:
+		return "OP_LOOP"
+	case opCall:
+		return "OP_CALL"
+	case opInvoke:
+		return "OP_INVOKE"
+	case opSuperInvoke:
+		return "OP_SUPER_INVOKE"
+	case opClosure:
+		return "OP_CLOSURE"
+	case opCloseUpvalue:
+		return "OP_CLOSE_UPVALUE"
+	case opReturn:
+		return "OP_RETURN"
+	case opClass:
+		return "OP_CLASS"
+	case opInherit:
+		return "OP_INHERIT"
+	case opMethod:
+		return "OP_METHOD"
+	case opTry:
+		return "OP_TRY"
+	case opEndTry:
+		return "OP_END_TRY"
+	case opCatch:
+		return "OP_CATCH"
+	case opThrow:
+		return "OP_THROW"
+	case opRethrow:
+		return "OP_RETHROW"
+	case opBuildList:
+		return "OP_BUILD_LIST"
+	case opBuildMap:
+		return "OP_BUILD_MAP"
+	case opGetIndex:
+		return "OP_GET_INDEX"
+	case opSetIndex:
+		return "OP_SET_INDEX"
+	case opStringify:
+		return "OP_STRINGIFY"
+	case opModulo:
+		return "OP_MODULO"
+	case opPower:
+		return "OP_POWER"
+	case opBitAnd:
+		return "OP_BIT_AND"
+	case opBitOr:
+		return "OP_BIT_OR"
+	case opBitXor:
+		return "OP_BIT_XOR"
+	case opShiftLeft:
+		return "OP_SHIFT_LEFT"
+	case opShiftRight:
+		return "OP_SHIFT_RIGHT"
+	case opDup:
+		return "OP_DUP"
+	case opDup2:
+		return "OP_DUP2"
+	case opRotate:
+		return "OP_ROTATE"
+	case opStaticMethod:
+		return "OP_STATIC_METHOD"
+	case opNop:
+		return "OP_NOP"
+	}
+	panic("Unknown opCode")
+}
+
+// chunk is the compiled code of one function. Tokens runs parallel to Code and
+// holds the token each byte was compiled from, so runtime errors can point at
+// the same place the tree-walker would. opInvoke and opSuperInvoke keep the
+// method name under the opcode and the call's paren under the argument count.
+// Steps also runs parallel to Code and holds, for the first byte of each
+// instruction, how many statements and expressions the tree-walker would count
+// by the time it got there, so Options.MaxSteps means the same on both
+// backends.
+type chunk struct {
+	Code      []byte
+	Tokens    []Token
+	Steps     []int
+	Constants []Value
+}
+
+func (c *chunk) write(b byte, token Token) {
+	c.Code = append(c.Code, b)
+	c.Tokens = append(c.Tokens, token)
+	c.Steps = append(c.Steps, 0)
+}
+
+func (c *chunk) addConstant(value Value) int {
+	c.Constants = append(c.Constants, value)
+	return len(c.Constants) - 1
+}
+
+func (c *chunk) readShort(offset int) int {
+	return int(c.Code[offset])<<8 | int(c.Code[offset+1])
+}
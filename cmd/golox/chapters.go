@@ -0,0 +1,156 @@
+package main
+
+import (
+	"maps"
+	"strings"
+)
+
+// The jlox chapters of the crafting interpreters test suite, copied from the
+// suite's tool/bin/test.dart. Each chapter maps paths under the suite, files
+// or directories, to "pass" or "skip", and the longest path containing a test
+// decides whether that chapter runs it. Chapters 4 to 7 are left out as they
+// only test the scanner and parser on their own.
+var (
+	// These are just for earlier chapters.
+	earlyChapters = map[string]string{
+		"test/scanning":    "skip",
+		"test/expressions": "skip",
+	}
+
+	// JVM doesn't correctly implement IEEE equality on boxed doubles.
+	javaNaNEquality = map[string]string{
+		"test/number/nan_equality.lox": "skip",
+	}
+
+	// No hardcoded limits in jlox.
+	noJavaLimits = map[string]string{
+		"test/limit/loop_too_large.lox":     "skip",
+		"test/limit/no_reuse_constants.lox": "skip",
+		"test/limit/too_many_constants.lox": "skip",
+		"test/limit/too_many_locals.lox":    "skip",
+		"test/limit/too_many_upvalues.lox":  "skip",
+
+		// Rely on JVM for stack overflow checking.
+		"test/limit/stack_overflow.lox": "skip",
+	}
+
+	// No classes in Java yet.
+	noJavaClasses = map[string]string{
+		"test/assignment/to_this.lox":                  "skip",
+		"test/call/object.lox":                         "skip",
+		"test/class":                                   "skip",
+		"test/closure/close_over_method_parameter.lox": "skip",
+		"test/constructor":                             "skip",
+		"test/field":                                   "skip",
+		"test/inheritance":                             "skip",
+		"test/method":                                  "skip",
+		"test/number/decimal_point_at_eof.lox":         "skip",
+		"test/number/trailing_dot.lox":                 "skip",
+		"test/operator/equals_class.lox":               "skip",
+		"test/operator/equals_method.lox":              "skip",
+		"test/operator/not_class.lox":                  "skip",
+		"test/regression/394.lox":                      "skip",
+		"test/super":                                   "skip",
+		"test/this":                                    "skip",
+		"test/return/in_method.lox":                    "skip",
+		"test/unexpected_character.lox":                "skip",
+	}
+
+	// No inheritance in Java yet.
+	noJavaInheritance = map[string]string{
+		"test/class/local_inherit_other.lox": "skip",
+		"test/class/local_inherit_self.lox":  "skip",
+		"test/class/inherit_self.lox":        "skip",
+		"test/class/inherited_method.lox":    "skip",
+		"test/inheritance":                   "skip",
+		"test/regression/394.lox":            "skip",
+		"test/super":                         "skip",
+	}
+
+	// No functions in Java yet.
+	noJavaFunctions = map[string]string{
+		"test/call":                      "skip",
+		"test/closure":                   "skip",
+		"test/for/closure_in_body.lox":   "skip",
+		"test/for/return_closure.lox":    "skip",
+		"test/for/return_inside.lox":     "skip",
+		"test/for/syntax.lox":            "skip",
+		"test/function":                  "skip",
+		"test/method/empty_block.lox":    "skip",
+		"test/operator/not.lox":          "skip",
+		"test/regression/40.lox":         "skip",
+		"test/return":                    "skip",
+		"test/unexpected_character.lox":  "skip",
+		"test/while/closure_in_body.lox": "skip",
+		"test/while/return_closure.lox":  "skip",
+		"test/while/return_inside.lox":   "skip",
+	}
+
+	// No resolution in Java yet.
+	noJavaResolution = map[string]string{
+		"test/closure/assign_to_shadowed_later.lox": "skip",
+		"test/function/local_mutual_recursion.lox":  "skip",
+		"test/variable/collide_with_parameter.lox":  "skip",
+		"test/variable/duplicate_local.lox":         "skip",
+		"test/variable/duplicate_parameter.lox":     "skip",
+		"test/variable/early_bound.lox":             "skip",
+
+		// Broken because we haven't fixed it yet by detecting the error.
+		"test/return/at_top_level.lox":               "skip",
+		"test/variable/use_local_in_initializer.lox": "skip",
+	}
+)
+
+type chapter struct {
+	name  string
+	tests map[string]string
+}
+
+var chapters = []chapter{
+	{"chap08_statements", suite(earlyChapters, javaNaNEquality, noJavaLimits, noJavaFunctions, noJavaResolution, noJavaClasses, map[string]string{
+		// No control flow.
+		"test/block/empty.lox":                  "skip",
+		"test/for":                              "skip",
+		"test/if":                               "skip",
+		"test/logical_operator":                 "skip",
+		"test/while":                            "skip",
+		"test/variable/unreached_undefined.lox": "skip",
+	})},
+	{"chap09_control", suite(earlyChapters, javaNaNEquality, noJavaLimits, noJavaFunctions, noJavaResolution, noJavaClasses)},
+	{"chap10_functions", suite(earlyChapters, javaNaNEquality, noJavaLimits, noJavaResolution, noJavaClasses)},
+	{"chap11_resolving", suite(earlyChapters, javaNaNEquality, noJavaLimits, noJavaClasses)},
+	{"chap12_classes", suite(earlyChapters, javaNaNEquality, noJavaLimits, noJavaInheritance)},
+	{"chap13_inheritance", suite(earlyChapters, javaNaNEquality, noJavaLimits)},
+}
+
+// suite runs every test but the ones the given groups skip.
+func suite(groups ...map[string]string) map[string]string {
+	tests := map[string]string{"test": "pass"}
+	for _, group := range groups {
+		maps.Copy(tests, group)
+	}
+	return tests
+}
+
+// chaptersFor returns the names of the chapters that run the test at path,
+// given relative to the suite's test directory with forward slashes.
+func chaptersFor(path string) []string {
+	var names []string
+	for _, c := range chapters {
+		state := ""
+		subpath := ""
+		for _, part := range strings.Split("test/"+path, "/") {
+			if subpath != "" {
+				subpath += "/"
+			}
+			subpath += part
+			if s, ok := c.tests[subpath]; ok {
+				state = s
+			}
+		}
+		if state == "pass" {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,113 @@
+// Command golox runs Lox scripts, or starts a REPL when given no script.
+//
+//	golox [flags] [script]
+//	golox test [flags] [dir...]
+//
+// The test subcommand runs every .lox file under the given directories and
+// checks it against the "// expect:" style annotations used by the crafting
+// interpreters test suite.
+//
+// By default code runs on the tree-walking interpreter, -backend=vm runs it
+// on the bytecode VM instead and -disassemble prints the compiled bytecode to
+// stderr before running it.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jastintime/lox"
+)
+
+var (
+	diagnosticsFormat = flag.String("diagnostics", "text", "format for errors on stderr: text, or json for one object per line")
+	backendName       = flag.String("backend", "tree", "how to run code: tree for the tree-walker, or vm for the bytecode VM")
+	disassembleFlag   = flag.Bool("disassemble", false, "print the bytecode compiled for the vm backend to stderr")
+)
+
+var backends = map[string]lox.Backend{
+	"tree": lox.TreeWalker,
+	"vm":   lox.BytecodeVM,
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage : golox [flags] [script]")
+		fmt.Fprintln(os.Stderr, "        golox test [flags] [dir...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	_, ok := backends[*backendName]
+	if !ok || *diagnosticsFormat != "text" && *diagnosticsFormat != "json" {
+		flag.Usage()
+		os.Exit(64)
+	}
+	if flag.Arg(0) == "test" {
+		os.Exit(runTests(flag.Args()[1:]))
+	}
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(64)
+	} else if flag.NArg() == 1 {
+		err := runFile(flag.Arg(0))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(74)
+		}
+	} else {
+		err := newInterpreter().RunPrompt()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func newInterpreter() *lox.Interpreter {
+	opts := lox.Options{Backend: backends[*backendName]}
+	if *disassembleFlag {
+		opts.Disassemble = os.Stderr
+	}
+	if *diagnosticsFormat == "json" {
+		opts.Stderr = io.Discard
+		opts.Diagnostics = jsonSink{json.NewEncoder(os.Stderr)}
+	}
+	return lox.New(opts)
+}
+
+func runFile(path string) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = newInterpreter().Run(string(source))
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
+	return nil
+}
+
+// jsonSink writes each diagnostic as a JSON object on its own line as soon as
+// it is reported.
+type jsonSink struct {
+	encoder *json.Encoder
+}
+
+func (s jsonSink) Report(d lox.Diagnostic) {
+	s.encoder.Encode(d)
+}
+
+// exitCode maps an error from Run onto the sysexits codes used by jlox.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var runtimeError lox.RuntimeError
+	if errors.As(err, &runtimeError) {
+		return 70
+	}
+	return 65
+}
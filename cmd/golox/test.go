@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jastintime/lox"
+)
+
+// The annotations understood by the crafting interpreters test suite. Errors
+// tagged for the C implementation are ignored.
+var (
+	expectedOutputPattern       = regexp.MustCompile(`// expect: ?(.*)`)
+	expectedErrorPattern        = regexp.MustCompile(`// (Error.*)`)
+	errorLinePattern            = regexp.MustCompile(`// \[((java|c) )?line (\d+)\] (Error.*)`)
+	expectedRuntimeErrorPattern = regexp.MustCompile(`// expect runtime error: (.+)`)
+	nonTestPattern              = regexp.MustCompile(`// nontest`)
+)
+
+const testTimeout = 10 * time.Second
+
+type expectedOutput struct {
+	line   int
+	output string
+}
+
+// test is a single .lox file and what it is expected to do. chapters are the
+// rows of the summary it counts toward.
+type test struct {
+	path             string
+	chapters         []string
+	output           []expectedOutput
+	compileErrors    []string
+	runtimeError     string
+	runtimeErrorLine int
+	exitCode         int
+	failures         []string
+}
+
+// runTests implements "golox test", which runs every .lox file under the
+// given directories and checks it against its annotations. Benchmarks are
+// skipped as they only print timings. With -chapters the directories are the
+// suite's test directory and the summary has a row for each jlox chapter,
+// counting the tests that chapter runs.
+func runTests(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "list passing tests as well as failing ones")
+	filter := flags.String("run", "", "only run tests whose path matches this regular expression")
+	bookChapters := flags.Bool("chapters", false, "summarize the crafting interpreters suite by the chapters of jlox")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage : golox test [flags] [dir...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	var run *regexp.Regexp
+	if *filter != "" {
+		var err error
+		run, err = regexp.Compile(*filter)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 64
+		}
+	}
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	var tests []*test
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() && entry.Name() == "benchmark" {
+				return fs.SkipDir
+			}
+			if entry.IsDir() || filepath.Ext(path) != ".lox" {
+				return nil
+			}
+			if run != nil && !run.MatchString(path) {
+				return nil
+			}
+			t, err := parseTest(root, path)
+			if err != nil || t == nil {
+				return err
+			}
+			if *bookChapters {
+				relative, err := filepath.Rel(root, path)
+				if err != nil {
+					return err
+				}
+				t.chapters = chaptersFor(filepath.ToSlash(relative))
+				if len(t.chapters) == 0 {
+					return nil
+				}
+			}
+			tests = append(tests, t)
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 74
+		}
+	}
+
+	var rows []string
+	if *bookChapters {
+		for _, c := range chapters {
+			rows = append(rows, c.name)
+		}
+	}
+	passed := make(map[string]int)
+	total := make(map[string]int)
+	failed := 0
+	for _, t := range tests {
+		t.run(backends[*backendName])
+		for _, row := range t.chapters {
+			if !slices.Contains(rows, row) {
+				rows = append(rows, row)
+			}
+			total[row]++
+			if len(t.failures) == 0 {
+				passed[row]++
+			}
+		}
+		if len(t.failures) == 0 {
+			if *verbose {
+				fmt.Println("PASS", t.path)
+			}
+			continue
+		}
+		failed++
+		fmt.Println("FAIL", t.path)
+		for _, failure := range t.failures {
+			fmt.Println("    ", failure)
+		}
+	}
+
+	if !*bookChapters {
+		slices.Sort(rows)
+	}
+	fmt.Println()
+	for _, row := range rows {
+		fmt.Printf("%-30s %d/%d\n", row, passed[row], total[row])
+	}
+	fmt.Printf("%-30s %d/%d\n", "total", len(tests)-failed, len(tests))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// parseTest reads the annotations out of the file at path. It counts toward
+// the first directory below root, or "." for files directly inside it. A nil
+// test is returned for files marked nontest.
+func parseTest(root string, path string) (*test, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := "."
+	relative, err := filepath.Rel(root, path)
+	if err == nil {
+		if first, _, found := strings.Cut(filepath.ToSlash(relative), "/"); found {
+			dir = first
+		}
+	}
+	t := &test{path: path, chapters: []string{dir}}
+	for i, line := range strings.Split(string(source), "\n") {
+		lineNumber := i + 1
+		if nonTestPattern.MatchString(line) {
+			return nil, nil
+		}
+		if match := expectedOutputPattern.FindStringSubmatch(line); match != nil {
+			t.output = append(t.output, expectedOutput{lineNumber, match[1]})
+			continue
+		}
+		if match := errorLinePattern.FindStringSubmatch(line); match != nil {
+			if match[2] != "c" {
+				t.compileErrors = append(t.compileErrors, fmt.Sprintf("[line %s] %s", match[3], match[4]))
+				t.exitCode = 65
+			}
+			continue
+		}
+		if match := expectedErrorPattern.FindStringSubmatch(line); match != nil {
+			t.compileErrors = append(t.compileErrors, fmt.Sprintf("[line %d] %s", lineNumber, match[1]))
+			t.exitCode = 65
+			continue
+		}
+		if match := expectedRuntimeErrorPattern.FindStringSubmatch(line); match != nil {
+			t.runtimeError = match[1]
+			t.runtimeErrorLine = lineNumber
+			t.exitCode = 70
+		}
+	}
+	return t, nil
+}
+
+// run executes the script in process on backend and records every way its
+// output, errors and exit code differ from the annotations.
+func (t *test) run(backend lox.Backend) {
+	source, err := os.ReadFile(t.path)
+	if err != nil {
+		t.fail("%v", err)
+		return
+	}
+	var stdout bytes.Buffer
+	var diagnostics lox.DiagnosticList
+	interpreter := lox.New(lox.Options{
+		Stdout:      &stdout,
+		Stderr:      io.Discard,
+		Stdin:       strings.NewReader(""),
+		Diagnostics: &diagnostics,
+		Backend:     backend,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	err = interpreter.RunContext(ctx, string(source))
+	var interrupt *lox.InterruptError
+	if errors.As(err, &interrupt) {
+		t.fail("%v", err)
+		return
+	}
+
+	var errorLines []string
+	for _, d := range diagnostics {
+		// The annotations only describe errors, as jlox has no warnings.
+		if d.Severity == lox.SeverityWarning {
+			continue
+		}
+		d.Notes = nil
+		errorLines = append(errorLines, strings.Split(d.String(), "\n")...)
+	}
+	if t.runtimeError != "" {
+		t.validateRuntimeError(errorLines)
+	} else {
+		t.validateCompileErrors(errorLines)
+	}
+	t.validateExitCode(exitCode(err))
+	t.validateOutput(stdout.String())
+}
+
+func (t *test) validateRuntimeError(errorLines []string) {
+	if len(errorLines) < 2 {
+		t.fail("Expected runtime error '%s' and got none.", t.runtimeError)
+		return
+	}
+	if errorLines[0] != t.runtimeError {
+		t.fail("Expected runtime error '%s' and got:", t.runtimeError)
+		t.fail("%s", errorLines[0])
+	}
+	// The innermost Lox frame of the trace, skipping any native one, is
+	// where the error happened.
+	frame := errorLines[1]
+	if strings.HasPrefix(frame, "[native]") && len(errorLines) > 2 {
+		frame = errorLines[2]
+	}
+	expectedLine := "[line " + strconv.Itoa(t.runtimeErrorLine) + "]"
+	if !strings.HasPrefix(frame, expectedLine) {
+		t.fail("Expected stack trace %s and got %s.", expectedLine, frame)
+	}
+}
+
+func (t *test) validateCompileErrors(errorLines []string) {
+	for _, line := range errorLines {
+		if !slices.Contains(t.compileErrors, line) {
+			t.fail("Unexpected error:")
+			t.fail("%s", line)
+		}
+	}
+	for _, expected := range t.compileErrors {
+		if !slices.Contains(errorLines, expected) {
+			t.fail("Missing expected error: %s", expected)
+		}
+	}
+}
+
+func (t *test) validateExitCode(code int) {
+	if code != t.exitCode {
+		t.fail("Expected return code %d and got %d.", t.exitCode, code)
+	}
+}
+
+func (t *test) validateOutput(output string) {
+	lines := strings.Split(output, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		if i >= len(t.output) {
+			t.fail("Got output '%s' when none was expected.", line)
+			continue
+		}
+		expected := t.output[i]
+		if expected.output != line {
+			t.fail("Expected output '%s' on line %d and got '%s'.", expected.output, expected.line, line)
+		}
+	}
+	for _, expected := range t.output[min(len(lines), len(t.output)):] {
+		t.fail("Missing expected output '%s' on line %d.", expected.output, expected.line)
+	}
+}
+
+func (t *test) fail(format string, args ...any) {
+	t.failures = append(t.failures, fmt.Sprintf(format, args...))
+}
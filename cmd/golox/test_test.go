@@ -0,0 +1,197 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeTest(t *testing.T, root string, name string, source string) string {
+	t.Helper()
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseTest(t *testing.T) {
+	root := t.TempDir()
+	path := writeTest(t, root, "scope/errors.lox", `print 1; // expect: 1
+print "a"; // expect:a
+var a = ; // Error at ';': Expect expression.
+// [line 9] Error at end: Expect '}' after block.
+// [c line 9] Error at end: Expect '}'.
+`)
+	got, err := parseTest(root, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []expectedOutput{{1, "1"}, {2, "a"}}
+	if !slices.Equal(got.output, want) {
+		t.Errorf("output = %v, want %v", got.output, want)
+	}
+	wantErrors := []string{
+		"[line 3] Error at ';': Expect expression.",
+		"[line 9] Error at end: Expect '}' after block.",
+	}
+	if !slices.Equal(got.compileErrors, wantErrors) {
+		t.Errorf("compileErrors = %q, want %q", got.compileErrors, wantErrors)
+	}
+	if got.exitCode != 65 {
+		t.Errorf("exitCode = %d, want 65", got.exitCode)
+	}
+	if !slices.Equal(got.chapters, []string{"scope"}) {
+		t.Errorf("chapters = %q, want [scope]", got.chapters)
+	}
+
+	path = writeTest(t, root, "top.lox", `print 1;
+nil.x; // expect runtime error: Only instances have properties.
+`)
+	got, err = parseTest(root, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.runtimeError != "Only instances have properties." || got.runtimeErrorLine != 2 || got.exitCode != 70 {
+		t.Errorf("runtime error %q on line %d exiting %d", got.runtimeError, got.runtimeErrorLine, got.exitCode)
+	}
+	if !slices.Equal(got.chapters, []string{"."}) {
+		t.Errorf("chapters = %q, want [.]", got.chapters)
+	}
+
+	path = writeTest(t, root, "helper.lox", "// nontest\nprint 1;\n")
+	got, err = parseTest(root, path)
+	if err != nil || got != nil {
+		t.Errorf("parseTest of a nontest file = %v, %v", got, err)
+	}
+}
+
+func TestChaptersFor(t *testing.T) {
+	all := []string{"chap08_statements", "chap09_control", "chap10_functions", "chap11_resolving", "chap12_classes", "chap13_inheritance"}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"assignment/global.lox", all},
+		{"while/syntax.lox", all[1:]},
+		{"for/syntax.lox", all[2:]},
+		{"function/local_mutual_recursion.lox", all[3:]},
+		{"class/empty.lox", all[4:]},
+		{"class/inherited_method.lox", all[5:]},
+		{"super/call_same_method.lox", all[5:]},
+		{"limit/stack_overflow.lox", nil},
+		{"limit/reuse_constants.lox", all},
+		{"number/nan_equality.lox", nil},
+		{"scanning/identifiers.lox", nil},
+		{"expressions/evaluate.lox", nil},
+	}
+	for _, example := range tests {
+		if got := chaptersFor(example.path); !slices.Equal(got, example.want) {
+			t.Errorf("chaptersFor(%q) = %q, want %q", example.path, got, example.want)
+		}
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+	expected := &test{output: []expectedOutput{{1, "a"}, {2, "b"}}}
+	expected.validateOutput("a\nb\n")
+	if expected.failures != nil {
+		t.Errorf("matching output failed with %q", expected.failures)
+	}
+
+	expected = &test{output: []expectedOutput{{1, "a"}, {2, "b"}}}
+	expected.validateOutput("a\nc\nd\n")
+	want := []string{
+		"Expected output 'b' on line 2 and got 'c'.",
+		"Got output 'd' when none was expected.",
+	}
+	if !slices.Equal(expected.failures, want) {
+		t.Errorf("failures = %q, want %q", expected.failures, want)
+	}
+
+	expected = &test{output: []expectedOutput{{1, "a"}, {2, "b"}}}
+	expected.validateOutput("a\n")
+	want = []string{"Missing expected output 'b' on line 2."}
+	if !slices.Equal(expected.failures, want) {
+		t.Errorf("failures = %q, want %q", expected.failures, want)
+	}
+}
+
+func TestValidateCompileErrors(t *testing.T) {
+	expected := &test{compileErrors: []string{"[line 1] Error at 'a': x.", "[line 2] Error at 'b': y."}}
+	expected.validateCompileErrors([]string{"[line 2] Error at 'b': y.", "[line 3] Error at 'c': z."})
+	want := []string{
+		"Unexpected error:",
+		"[line 3] Error at 'c': z.",
+		"Missing expected error: [line 1] Error at 'a': x.",
+	}
+	if !slices.Equal(expected.failures, want) {
+		t.Errorf("failures = %q, want %q", expected.failures, want)
+	}
+}
+
+func TestValidateRuntimeError(t *testing.T) {
+	tests := []struct {
+		errorLines []string
+		want       []string
+	}{
+		{[]string{"Boom.", "[line 3] in script"}, nil},
+		{[]string{"Boom.", "[native] in fail()", "[line 3] in script"}, nil},
+		{[]string{"Bang.", "[line 4] in f()", "[line 3] in script"}, []string{
+			"Expected runtime error 'Boom.' and got:",
+			"Bang.",
+			"Expected stack trace [line 3] and got [line 4] in f().",
+		}},
+		{nil, []string{"Expected runtime error 'Boom.' and got none."}},
+	}
+	for _, example := range tests {
+		expected := &test{runtimeError: "Boom.", runtimeErrorLine: 3}
+		expected.validateRuntimeError(example.errorLines)
+		if !slices.Equal(expected.failures, example.want) {
+			t.Errorf("validateRuntimeError(%q) failures = %q, want %q", example.errorLines, expected.failures, example.want)
+		}
+	}
+}
+
+func TestValidateExitCode(t *testing.T) {
+	expected := &test{exitCode: 65}
+	expected.validateExitCode(65)
+	expected.validateExitCode(70)
+	want := []string{"Expected return code 65 and got 70."}
+	if !slices.Equal(expected.failures, want) {
+		t.Errorf("failures = %q, want %q", expected.failures, want)
+	}
+}
+
+// TestScripts checks the scripts at the root of the repository against their
+// annotations on both backends.
+func TestScripts(t *testing.T) {
+	paths, err := filepath.Glob("../../*.lox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no scripts found")
+	}
+	for name, backend := range backends {
+		for _, path := range paths {
+			t.Run(name+"/"+filepath.Base(path), func(t *testing.T) {
+				script, err := parseTest("../..", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if script == nil {
+					t.Skip("nontest")
+				}
+				script.run(backend)
+				for _, failure := range script.failures {
+					t.Error(failure)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,885 @@
+package lox
+
+import (
+	"github.com/jastintime/lox/internal/ClassType"
+	"github.com/jastintime/lox/internal/FunctionType"
+)
+
+const maxShort = 1<<16 - 1
+
+// compiler turns resolved statements into bytecode for the VM. It runs after
+// the Resolver so it can assume the program is free of static errors, and does
+// its own scope tracking to give every local a stack slot and every captured
+// variable an upvalue.
+type compiler struct {
+	enclosing   *compiler
+	function    *vmFunction
+	kind        functionType.FunctionType
+	locals      []compilerLocal
+	upvalues    []compilerUpvalue
+	scopeDepth  int
+	class       *classCompiler
+	loop        *compilerLoop
+	diagnostics DiagnosticSink
+	// handlers are the try statements whose handler is active at the code
+	// being compiled, innermost last.
+	handlers []compilerHandler
+	// last is the most recent real token emitted, or the token of the
+	// statement being compiled if that is more recent. Literals and other code
+	// without a token of their own are attributed to it.
+	last Token
+	// steps is how many statements and expressions have been compiled since
+	// the last instruction was emitted. They are charged to the next one.
+	steps int
+}
+
+type compilerLocal struct {
+	name       string
+	depth      int
+	isCaptured bool
+}
+
+type compilerUpvalue struct {
+	index   int
+	isLocal bool
+}
+
+// compilerLoop is a loop whose body is being compiled, so break and continue
+// know where to jump and which locals to discard on the way.
+type compilerLoop struct {
+	enclosing *compilerLoop
+	stmt      *whileStmt
+	// scopeDepth is the depth of the scope the loop is in, locals deeper than
+	// it are discarded by a jump out of the body.
+	scopeDepth int
+	// handlers is how many handlers were active outside the loop.
+	handlers int
+	// breaks and continues are jumps waiting for the end of the loop and the
+	// increment to be emitted.
+	breaks    []int
+	continues []int
+}
+
+// compilerHandler is an active try handler. A jump out of its try statement
+// has to end the handler and run finally, if it has one, on the way out.
+type compilerHandler struct {
+	finally *blockStmt
+	// loop is the loop around the try statement, which break and continue in
+	// finally refer to.
+	loop *compilerLoop
+}
+
+type classCompiler struct {
+	enclosing *classCompiler
+	name      string
+	kind      classType.ClassType
+}
+
+func newCompiler(enclosing *compiler, kind functionType.FunctionType, name string, diagnostics DiagnosticSink) *compiler {
+	c := &compiler{
+		enclosing:   enclosing,
+		function:    &vmFunction{name: name},
+		kind:        kind,
+		diagnostics: diagnostics,
+	}
+	if enclosing != nil {
+		c.class = enclosing.class
+		c.last = enclosing.last
+	}
+	// slot zero holds the function being called, or the receiver in methods
+	slot := ""
+	if kind == functionType.Method || kind == functionType.Initializer {
+		slot = "this"
+		c.function.class = c.class.name
+	}
+	c.locals = append(c.locals, compilerLocal{slot, 0, false})
+	return c
+}
+
+// compile compiles a whole script into the function the VM starts with.
+func compile(statements []stmt, diagnostics DiagnosticSink) *vmFunction {
+	c := newCompiler(nil, functionType.None, "", diagnostics)
+	for _, statement := range statements {
+		c.statement(statement)
+	}
+	c.emitReturn(Token{})
+	return c.function
+}
+
+func (c *compiler) statement(stmt stmt) {
+	c.steps++
+	stmt.accept(c)
+}
+
+func (c *compiler) expression(expr expr) {
+	c.steps++
+	expr.accept(c)
+}
+
+func (c *compiler) visitBlockStmt(stmt *blockStmt) any {
+	c.beginScope()
+	for _, statement := range stmt.Statements {
+		c.statement(statement)
+	}
+	c.endScope(Token{})
+	return nil
+}
+
+func (c *compiler) visitClassStmt(stmt *classStmt) any {
+	name := c.identifierConstant(stmt.Name)
+	c.declareVariable(stmt.Name)
+	c.emitShort(opClass, name, stmt.Name)
+	c.defineVariable(name, stmt.Name)
+
+	class := &classCompiler{c.class, stmt.Name.Lexeme, classType.Class}
+	c.class = class
+
+	if stmt.Superclass != nil {
+		c.expression(stmt.Superclass)
+		c.beginScope()
+		c.addLocal("super")
+		c.markInitialized()
+		c.namedVariable(stmt.Name, false)
+		c.emit(opInherit, stmt.Superclass.Name)
+		class.kind = classType.Subclass
+	}
+
+	c.namedVariable(stmt.Name, false)
+	for _, method := range stmt.Methods {
+		kind := functionType.Method
+		if method.Name.Lexeme == "init" {
+			kind = functionType.Initializer
+		}
+		c.function_(method, kind)
+		c.emitShort(opMethod, c.identifierConstant(method.Name), method.Name)
+	}
+	for _, method := range stmt.StaticMethods {
+		c.function_(method, functionType.Method)
+		c.emitShort(opStaticMethod, c.identifierConstant(method.Name), method.Name)
+	}
+	c.emit(opPop, stmt.Name)
+
+	if stmt.Superclass != nil {
+		c.endScope(stmt.Name)
+	}
+	c.class = class.enclosing
+	return nil
+}
+
+func (c *compiler) visitExprStmt(stmt *exprStmt) any {
+	c.last = stmt.Start
+	c.expression(stmt.Expression)
+	c.emit(opPop, Token{})
+	return nil
+}
+
+func (c *compiler) visitFunctionStmt(stmt *functionStmt) any {
+	global := c.identifierConstant(stmt.Name)
+	c.declareVariable(stmt.Name)
+	c.markInitialized()
+	c.function_(stmt, functionType.Function)
+	c.defineVariable(global, stmt.Name)
+	return nil
+}
+
+func (c *compiler) visitIfStmt(stmt *ifStmt) any {
+	c.last = stmt.Keyword
+	c.expression(stmt.Condition)
+	thenJump := c.emitJump(opJumpIfFalse, Token{})
+	c.emit(opPop, Token{})
+	c.statement(stmt.ThenBranch)
+	elseJump := c.emitJump(opJump, Token{})
+	c.patchJump(thenJump)
+	c.emit(opPop, Token{})
+	if stmt.ElseBranch != nil {
+		c.statement(stmt.ElseBranch)
+	}
+	c.patchJump(elseJump)
+	return nil
+}
+
+func (c *compiler) visitPrintStmt(stmt *printStmt) any {
+	c.last = stmt.Keyword
+	c.expression(stmt.Expression)
+	c.emit(opPrint, Token{})
+	return nil
+}
+
+func (c *compiler) visitReturnStmt(stmt *returnStmt) any {
+	c.last = stmt.Keyword
+	if stmt.Value == nil || c.kind == functionType.Initializer {
+		c.leaveHandlers(0, stmt.Keyword)
+		c.emitReturn(stmt.Keyword)
+		return nil
+	}
+	c.expression(stmt.Value)
+	if len(c.handlers) > 0 {
+		// Keep the result as a local so finally clauses get the slots they
+		// were given.
+		c.addLocal("")
+		c.markInitialized()
+		c.leaveHandlers(0, stmt.Keyword)
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+	c.emit(opReturn, stmt.Keyword)
+	return nil
+}
+
+// visitThrowStmt throws the value on top of the stack. opThrow is attributed
+// to the keyword, which is where the error is reported.
+func (c *compiler) visitThrowStmt(stmt *throwStmt) any {
+	c.last = stmt.Keyword
+	c.expression(stmt.Value)
+	c.emit(opThrow, stmt.Keyword)
+	return nil
+}
+
+// visitTryStmt compiles the finally clause twice: once for leaving the try
+// statement normally and once as a handler that runs it with the error
+// being unwound kept in a hidden local, then throws that again.
+func (c *compiler) visitTryStmt(stmt *tryStmt) any {
+	if stmt.Finally == nil {
+		c.tryCatch(stmt)
+		return nil
+	}
+	handler := c.emitJump(opTry, stmt.Keyword)
+	c.handlers = append(c.handlers, compilerHandler{stmt.Finally, c.loop})
+	c.tryCatch(stmt)
+	c.handlers = c.handlers[:len(c.handlers)-1]
+	c.emit(opEndTry, Token{})
+	c.statement(stmt.Finally)
+	end := c.emitJump(opJump, Token{})
+
+	c.patchJump(handler)
+	c.beginScope()
+	c.addLocal("")
+	c.markInitialized()
+	c.statement(stmt.Finally)
+	c.emit(opRethrow, Token{})
+	c.scopeDepth--
+	c.locals = c.locals[:len(c.locals)-1]
+	c.patchJump(end)
+	return nil
+}
+
+// tryCatch compiles the body of a try statement and its catch clause, which
+// shares a scope with the exception variable.
+func (c *compiler) tryCatch(stmt *tryStmt) {
+	if stmt.Catch == nil {
+		c.statement(stmt.Body)
+		return
+	}
+	handler := c.emitJump(opTry, stmt.Keyword)
+	c.handlers = append(c.handlers, compilerHandler{nil, c.loop})
+	c.statement(stmt.Body)
+	c.handlers = c.handlers[:len(c.handlers)-1]
+	c.emit(opEndTry, Token{})
+	end := c.emitJump(opJump, Token{})
+
+	c.patchJump(handler)
+	c.beginScope()
+	c.emit(opCatch, *stmt.CatchName)
+	c.addLocal(stmt.CatchName.Lexeme)
+	c.markInitialized()
+	for _, statement := range stmt.Catch.Statements {
+		c.statement(statement)
+	}
+	c.endScope(Token{})
+	c.patchJump(end)
+}
+
+// leaveHandlers emits what a jump to outside the innermost handlers, all but
+// the first count, has to do on the way: end each handler and run its
+// finally clause.
+func (c *compiler) leaveHandlers(count int, token Token) {
+	handlers, loop := c.handlers, c.loop
+	for k := len(handlers) - 1; k >= count; k-- {
+		c.emit(opEndTry, token)
+		if handlers[k].finally != nil {
+			c.handlers, c.loop = handlers[:k], handlers[k].loop
+			c.statement(handlers[k].finally)
+		}
+	}
+	c.handlers, c.loop = handlers, loop
+}
+
+func (c *compiler) visitVariableStmt(stmt *variableStmt) any {
+	c.last = stmt.Name
+	global := c.identifierConstant(stmt.Name)
+	c.declareVariable(stmt.Name)
+	if stmt.Initializer != nil {
+		c.expression(stmt.Initializer)
+	} else {
+		c.emit(opNil, stmt.Name)
+	}
+	c.defineVariable(global, stmt.Name)
+	return nil
+}
+
+func (c *compiler) visitWhileStmt(stmt *whileStmt) any {
+	c.last = stmt.Keyword
+	c.flushSteps()
+	loopStart := len(c.chunk().Code)
+	c.expression(stmt.Condition)
+	exitJump := c.emitJump(opJumpIfFalse, Token{})
+	c.emit(opPop, Token{})
+	loop := &compilerLoop{c.loop, stmt, c.scopeDepth, len(c.handlers), nil, nil}
+	c.loop = loop
+	c.statement(stmt.Body)
+	c.loop = loop.enclosing
+	for _, jump := range loop.continues {
+		c.patchJump(jump)
+	}
+	if stmt.Increment != nil {
+		c.expression(stmt.Increment)
+		c.emit(opPop, Token{})
+	}
+	c.emitLoop(loopStart, Token{})
+	c.patchJump(exitJump)
+	c.emit(opPop, Token{})
+	for _, jump := range loop.breaks {
+		c.patchJump(jump)
+	}
+	return nil
+}
+
+func (c *compiler) visitBreakStmt(stmt *breakStmt) any {
+	loop := c.targetLoop(stmt.Label)
+	c.leaveHandlers(loop.handlers, stmt.Keyword)
+	c.discardLocals(loop.scopeDepth, stmt.Keyword)
+	loop.breaks = append(loop.breaks, c.emitJump(opJump, stmt.Keyword))
+	return nil
+}
+
+func (c *compiler) visitContinueStmt(stmt *continueStmt) any {
+	loop := c.targetLoop(stmt.Label)
+	c.leaveHandlers(loop.handlers, stmt.Keyword)
+	c.discardLocals(loop.scopeDepth, stmt.Keyword)
+	loop.continues = append(loop.continues, c.emitJump(opJump, stmt.Keyword))
+	return nil
+}
+
+// targetLoop finds the loop a break or continue with label jumps out of. The
+// Resolver has already checked there is one.
+func (c *compiler) targetLoop(label *Token) *compilerLoop {
+	loop := c.loop
+	for !targets(loop.stmt, label) {
+		loop = loop.enclosing
+	}
+	return loop
+}
+
+func (c *compiler) visitAssignExpr(expr *assignExpr) Value {
+	c.expression(expr.Value)
+	c.namedVariable(expr.Name, true)
+	return Value{}
+}
+
+func (c *compiler) visitBinaryExpr(expr *binaryExpr) Value {
+	c.expression(expr.Left)
+	c.expression(expr.Right)
+	c.binary(expr.Operator)
+	return Value{}
+}
+
+// binary emits the instructions for a binary operator, or the one a compound
+// assignment stands for.
+func (c *compiler) binary(operator Token) {
+	switch operator.Type {
+	case BangEqual:
+		c.emit(opEqual, operator)
+		c.emit(opNot, operator)
+	case EqualEqual:
+		c.emit(opEqual, operator)
+	case Greater:
+		c.emit(opGreater, operator)
+	case GreaterEqual:
+		c.emit(opGreaterEqual, operator)
+	case Less:
+		c.emit(opLess, operator)
+	case LessEqual:
+		c.emit(opLessEqual, operator)
+	case Plus:
+		c.emit(opAdd, operator)
+	case Minus:
+		c.emit(opSubtract, operator)
+	case Star:
+		c.emit(opMultiply, operator)
+	case Slash:
+		c.emit(opDivide, operator)
+	case Percent:
+		c.emit(opModulo, operator)
+	case StarStar:
+		c.emit(opPower, operator)
+	case Ampersand:
+		c.emit(opBitAnd, operator)
+	case Pipe:
+		c.emit(opBitOr, operator)
+	case Caret:
+		c.emit(opBitXor, operator)
+	case LessLess:
+		c.emit(opShiftLeft, operator)
+	case GreaterGreater:
+		c.emit(opShiftRight, operator)
+	}
+}
+
+func (c *compiler) visitCallExpr(expr *callExpr) Value {
+	get, ok := expr.Callee.(*getExpr)
+	if ok {
+		// The callee is still a step, even though it isn't compiled on its own.
+		c.steps++
+		c.expression(get.Object)
+		c.arguments(expr.Arguments)
+		c.emitInvoke(opInvoke, get.Name, expr)
+		return Value{}
+	}
+	super, ok := expr.Callee.(*superExpr)
+	if ok {
+		c.steps++
+		c.namedVariable(Token{Type: This, Lexeme: "this", Line: super.Keyword.Line}, false)
+		c.arguments(expr.Arguments)
+		c.namedVariable(super.Keyword, false)
+		c.emitInvoke(opSuperInvoke, super.Method, expr)
+		return Value{}
+	}
+	c.expression(expr.Callee)
+	c.arguments(expr.Arguments)
+	c.emit(opCall, expr.Paren)
+	c.write(byte(len(expr.Arguments)), expr.Paren)
+	return Value{}
+}
+
+func (c *compiler) arguments(arguments []expr) {
+	for _, argument := range arguments {
+		c.expression(argument)
+	}
+}
+
+func (c *compiler) emitInvoke(op opCode, name Token, call *callExpr) {
+	c.emitShort(op, c.identifierConstant(name), name)
+	c.write(byte(len(call.Arguments)), call.Paren)
+}
+
+func (c *compiler) visitGetExpr(expr *getExpr) Value {
+	c.expression(expr.Object)
+	c.emitShort(opGetProperty, c.identifierConstant(expr.Name), expr.Name)
+	return Value{}
+}
+
+func (c *compiler) visitIndexExpr(expr *indexExpr) Value {
+	c.expression(expr.Object)
+	c.expression(expr.Index)
+	c.emit(opGetIndex, expr.Bracket)
+	return Value{}
+}
+
+func (c *compiler) visitIndexSetExpr(expr *indexSetExpr) Value {
+	c.expression(expr.Object)
+	c.expression(expr.Index)
+	c.expression(expr.Value)
+	c.emit(opSetIndex, expr.Bracket)
+	return Value{}
+}
+
+func (c *compiler) visitListExpr(expr *listExpr) Value {
+	for _, element := range expr.Elements {
+		c.expression(element)
+	}
+	if len(expr.Elements) > maxShort {
+		c.error(expr.Bracket, "Too many elements in list literal.")
+	}
+	c.emitShort(opBuildList, len(expr.Elements), expr.Bracket)
+	return Value{}
+}
+
+func (c *compiler) visitMapExpr(expr *mapExpr) Value {
+	for k := range expr.Keys {
+		c.expression(expr.Keys[k])
+		c.expression(expr.Values[k])
+	}
+	if len(expr.Keys) > maxShort {
+		c.error(expr.Brace, "Too many entries in map literal.")
+	}
+	c.emitShort(opBuildMap, len(expr.Keys), expr.Brace)
+	return Value{}
+}
+
+func (c *compiler) visitGroupingExpr(expr *groupingExpr) Value {
+	c.expression(expr.Expression)
+	return Value{}
+}
+
+func (c *compiler) visitConditionalExpr(expr *conditionalExpr) Value {
+	c.expression(expr.Condition)
+	elseJump := c.emitJump(opJumpIfFalse, expr.Question)
+	c.emit(opPop, expr.Question)
+	c.expression(expr.ThenBranch)
+	endJump := c.emitJump(opJump, expr.Question)
+	c.patchJump(elseJump)
+	c.emit(opPop, expr.Question)
+	c.expression(expr.ElseBranch)
+	c.patchJump(endJump)
+	return Value{}
+}
+
+func (c *compiler) visitCommaExpr(expr *commaExpr) Value {
+	c.expression(expr.Left)
+	c.emit(opPop, Token{})
+	c.expression(expr.Right)
+	return Value{}
+}
+
+// visitUpdateExpr keeps the object and index of the target on the stack,
+// duplicated for reading it, so they are only evaluated once. A postfix
+// update tucks a copy of the old value under them to be left as the result.
+func (c *compiler) visitUpdateExpr(expr *updateExpr) Value {
+	switch target := expr.Target.(type) {
+	case *variableExpr:
+		c.namedVariable(target.Name, false)
+		if expr.Postfix {
+			c.namedVariable(target.Name, false)
+		}
+		c.expression(expr.Value)
+		c.binary(expr.Operator)
+		c.namedVariable(target.Name, true)
+	case *getExpr:
+		name := c.identifierConstant(target.Name)
+		c.expression(target.Object)
+		c.emit(opDup, target.Name)
+		c.emitShort(opGetProperty, name, target.Name)
+		if expr.Postfix {
+			c.emit(opDup, expr.Operator)
+			c.emit(opRotate, expr.Operator)
+			c.write(2, expr.Operator)
+		}
+		c.expression(expr.Value)
+		c.binary(expr.Operator)
+		c.emitShort(opSetProperty, name, target.Name)
+	case *indexExpr:
+		c.expression(target.Object)
+		c.expression(target.Index)
+		c.emit(opDup2, target.Bracket)
+		c.emit(opGetIndex, target.Bracket)
+		if expr.Postfix {
+			c.emit(opDup, expr.Operator)
+			c.emit(opRotate, expr.Operator)
+			c.write(3, expr.Operator)
+		}
+		c.expression(expr.Value)
+		c.binary(expr.Operator)
+		c.emit(opSetIndex, target.Bracket)
+	}
+	if expr.Postfix {
+		c.emit(opPop, expr.Operator)
+	}
+	return Value{}
+}
+
+func (c *compiler) visitFunctionExpr(expr *functionExpr) Value {
+	c.function_(expr.Function, functionType.Function)
+	return Value{}
+}
+
+func (c *compiler) visitStringifyExpr(expr *stringifyExpr) Value {
+	c.expression(expr.Expression)
+	c.emit(opStringify, Token{})
+	return Value{}
+}
+
+func (c *compiler) visitLiteralExpr(expr *literalExpr) Value {
+	switch {
+	case expr.Value.IsNil():
+		c.emit(opNil, Token{})
+	case expr.Value == BoolValue(true):
+		c.emit(opTrue, Token{})
+	case expr.Value == BoolValue(false):
+		c.emit(opFalse, Token{})
+	default:
+		c.emitConstant(expr.Value, Token{})
+	}
+	return Value{}
+}
+
+func (c *compiler) visitLogicalExpr(expr *logicalExpr) Value {
+	c.expression(expr.Left)
+	if expr.Operator.Type == Or {
+		elseJump := c.emitJump(opJumpIfFalse, expr.Operator)
+		endJump := c.emitJump(opJump, expr.Operator)
+		c.patchJump(elseJump)
+		c.emit(opPop, expr.Operator)
+		c.expression(expr.Right)
+		c.patchJump(endJump)
+		return Value{}
+	}
+	endJump := c.emitJump(opJumpIfFalse, expr.Operator)
+	c.emit(opPop, expr.Operator)
+	c.expression(expr.Right)
+	c.patchJump(endJump)
+	return Value{}
+}
+
+func (c *compiler) visitSetExpr(expr *setExpr) Value {
+	c.expression(expr.Object)
+	c.expression(expr.Value)
+	c.emitShort(opSetProperty, c.identifierConstant(expr.Name), expr.Name)
+	return Value{}
+}
+
+func (c *compiler) visitSuperExpr(expr *superExpr) Value {
+	c.namedVariable(Token{Type: This, Lexeme: "this", Line: expr.Keyword.Line}, false)
+	c.namedVariable(expr.Keyword, false)
+	c.emitShort(opGetSuper, c.identifierConstant(expr.Method), expr.Method)
+	return Value{}
+}
+
+func (c *compiler) visitThisExpr(expr *thisExpr) Value {
+	c.namedVariable(expr.Keyword, false)
+	return Value{}
+}
+
+func (c *compiler) visitUnaryExpr(expr *unaryExpr) Value {
+	c.expression(expr.Right)
+	switch expr.Operator.Type {
+	case Bang:
+		c.emit(opNot, expr.Operator)
+	case Minus:
+		c.emit(opNegate, expr.Operator)
+	}
+	return Value{}
+}
+
+func (c *compiler) visitVariableExpr(expr *variableExpr) Value {
+	c.namedVariable(expr.Name, false)
+	return Value{}
+}
+
+// function_ compiles the body of a function declaration or method in a new
+// compiler and emits the opClosure that creates it at runtime.
+func (c *compiler) function_(stmt *functionStmt, kind functionType.FunctionType) {
+	compiler := newCompiler(c, kind, stmt.Name.Lexeme, c.diagnostics)
+	compiler.beginScope()
+	for _, param := range stmt.Params {
+		compiler.function.arity++
+		constant := compiler.identifierConstant(param)
+		compiler.declareVariable(param)
+		compiler.defineVariable(constant, param)
+	}
+	for _, statement := range stmt.Body {
+		compiler.statement(statement)
+	}
+	compiler.emitReturn(Token{})
+
+	function := compiler.function
+	function.upvalueCount = len(compiler.upvalues)
+	c.emitShort(opClosure, c.makeConstant(objectValue(FunctionKind, function), stmt.Name), stmt.Name)
+	for _, upvalue := range compiler.upvalues {
+		isLocal := byte(0)
+		if upvalue.isLocal {
+			isLocal = 1
+		}
+		c.write(isLocal, stmt.Name)
+		c.write(byte(upvalue.index>>8), stmt.Name)
+		c.write(byte(upvalue.index), stmt.Name)
+	}
+}
+
+func (c *compiler) namedVariable(name Token, assign bool) {
+	var get, set opCode
+	arg := c.resolveLocal(name)
+	if arg != -1 {
+		get, set = opGetLocal, opSetLocal
+	} else if arg = c.resolveUpvalue(name); arg != -1 {
+		get, set = opGetUpvalue, opSetUpvalue
+	} else {
+		arg = c.identifierConstant(name)
+		get, set = opGetGlobal, opSetGlobal
+	}
+	if assign {
+		c.emitShort(set, arg, name)
+	} else {
+		c.emitShort(get, arg, name)
+	}
+}
+
+func (c *compiler) resolveLocal(name Token) int {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i].name == name.Lexeme {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *compiler) resolveUpvalue(name Token) int {
+	if c.enclosing == nil {
+		return -1
+	}
+	local := c.enclosing.resolveLocal(name)
+	if local != -1 {
+		c.enclosing.locals[local].isCaptured = true
+		return c.addUpvalue(local, true, name)
+	}
+	upvalue := c.enclosing.resolveUpvalue(name)
+	if upvalue != -1 {
+		return c.addUpvalue(upvalue, false, name)
+	}
+	return -1
+}
+
+func (c *compiler) addUpvalue(index int, isLocal bool, name Token) int {
+	for i, upvalue := range c.upvalues {
+		if upvalue.index == index && upvalue.isLocal == isLocal {
+			return i
+		}
+	}
+	if len(c.upvalues) == maxShort {
+		c.error(name, "Too many closure variables in function.")
+		return 0
+	}
+	c.upvalues = append(c.upvalues, compilerUpvalue{index, isLocal})
+	return len(c.upvalues) - 1
+}
+
+func (c *compiler) declareVariable(name Token) {
+	if c.scopeDepth == 0 {
+		return
+	}
+	c.addLocal(name.Lexeme)
+	if len(c.locals) > maxShort {
+		c.error(name, "Too many local variables in function.")
+	}
+}
+
+func (c *compiler) addLocal(name string) {
+	// a depth of -1 marks the local as declared but not yet initialized
+	c.locals = append(c.locals, compilerLocal{name, -1, false})
+}
+
+func (c *compiler) defineVariable(global int, name Token) {
+	if c.scopeDepth > 0 {
+		c.markInitialized()
+		return
+	}
+	c.emitShort(opDefineGlobal, global, name)
+}
+
+func (c *compiler) markInitialized() {
+	if c.scopeDepth == 0 {
+		return
+	}
+	c.locals[len(c.locals)-1].depth = c.scopeDepth
+}
+
+func (c *compiler) beginScope() {
+	c.scopeDepth++
+}
+
+func (c *compiler) endScope(token Token) {
+	c.scopeDepth--
+	c.discardLocals(c.scopeDepth, token)
+	for len(c.locals) > 0 && c.locals[len(c.locals)-1].depth > c.scopeDepth {
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+}
+
+// discardLocals emits code to pop the locals deeper than depth off the stack,
+// closing any that were captured. It leaves them declared, as a jump out of
+// their scope does.
+func (c *compiler) discardLocals(depth int, token Token) {
+	for k := len(c.locals) - 1; k >= 0 && c.locals[k].depth > depth; k-- {
+		if c.locals[k].isCaptured {
+			c.emit(opCloseUpvalue, token)
+		} else {
+			c.emit(opPop, token)
+		}
+	}
+}
+
+func (c *compiler) identifierConstant(name Token) int {
+	return c.makeConstant(StringValue(name.Lexeme), name)
+}
+
+func (c *compiler) makeConstant(value Value, token Token) int {
+	constant := c.chunk().addConstant(value)
+	if constant > maxShort {
+		c.error(token, "Too many constants in one chunk.")
+		return 0
+	}
+	return constant
+}
+
+func (c *compiler) chunk() *chunk {
+	return &c.function.chunk
+}
+
+func (c *compiler) write(b byte, token Token) {
+	if token.Line == 0 {
+		token = c.last
+	} else {
+		c.last = token
+	}
+	c.chunk().write(b, token)
+}
+
+func (c *compiler) emit(op opCode, token Token) {
+	c.write(byte(op), token)
+	c.chunk().Steps[len(c.chunk().Steps)-1] = c.steps
+	c.steps = 0
+}
+
+// flushSteps is called where a jump can land. Steps compiled before it are
+// only counted on the way there, so they can't wait for the next instruction.
+func (c *compiler) flushSteps() {
+	if c.steps > 0 {
+		c.emit(opNop, Token{})
+	}
+}
+
+func (c *compiler) emitShort(op opCode, operand int, token Token) {
+	c.emit(op, token)
+	c.write(byte(operand>>8), token)
+	c.write(byte(operand), token)
+}
+
+func (c *compiler) emitConstant(value Value, token Token) {
+	c.emitShort(opConstant, c.makeConstant(value, token), token)
+}
+
+func (c *compiler) emitReturn(token Token) {
+	if c.kind == functionType.Initializer {
+		c.emitShort(opGetLocal, 0, token)
+	} else {
+		c.emit(opNil, token)
+	}
+	c.emit(opReturn, token)
+}
+
+// emitJump emits a jump with a placeholder offset and returns where the offset
+// is so patchJump can fill it in once the target is known.
+func (c *compiler) emitJump(op opCode, token Token) int {
+	c.emitShort(op, maxShort, token)
+	return len(c.chunk().Code) - 2
+}
+
+func (c *compiler) patchJump(offset int) {
+	c.flushSteps()
+	jump := len(c.chunk().Code) - offset - 2
+	if jump > maxShort {
+		c.error(c.chunk().Tokens[offset], "Too much code to jump over.")
+	}
+	c.chunk().Code[offset] = byte(jump >> 8)
+	c.chunk().Code[offset+1] = byte(jump)
+}
+
+func (c *compiler) emitLoop(loopStart int, token Token) {
+	offset := len(c.chunk().Code) - loopStart + 3
+	if offset > maxShort {
+		c.error(token, "Loop body too large.")
+	}
+	c.emitShort(opLoop, offset, token)
+}
+
+func (c *compiler) error(t Token, message string) {
+	c.diagnostics.Report(Diagnostic{SeverityError, CodeCompileLimit, message, tokenSpan(t), nil, nil})
+}
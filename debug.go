@@ -0,0 +1,76 @@
+package lox
+
+import (
+	"fmt"
+	"io"
+)
+
+// disassemble writes a listing of function's chunk to w, followed by the
+// listings of every function compiled inside it.
+func disassemble(w io.Writer, function *vmFunction) {
+	chunk := &function.chunk
+	fmt.Fprintf(w, "== %s ==\n", function)
+	for offset := 0; offset < len(chunk.Code); {
+		offset = disassembleInstruction(w, chunk, offset)
+	}
+	for _, constant := range chunk.Constants {
+		nested, ok := constant.object.(*vmFunction)
+		if ok {
+			disassemble(w, nested)
+		}
+	}
+}
+
+// disassembleInstruction writes the instruction at offset and returns the
+// offset of the next one.
+func disassembleInstruction(w io.Writer, chunk *chunk, offset int) int {
+	fmt.Fprintf(w, "%04d ", offset)
+	if offset > 0 && chunk.Tokens[offset].Line == chunk.Tokens[offset-1].Line {
+		fmt.Fprint(w, "   | ")
+	} else {
+		fmt.Fprintf(w, "%4d ", chunk.Tokens[offset].Line)
+	}
+
+	op := opCode(chunk.Code[offset])
+	switch op {
+	case opConstant, opGetGlobal, opDefineGlobal, opSetGlobal, opGetProperty, opSetProperty,
+		opGetSuper, opClass, opMethod, opStaticMethod:
+		constant := chunk.readShort(offset + 1)
+		fmt.Fprintf(w, "%-16s %4d '%v'\n", op, constant, chunk.Constants[constant])
+		return offset + 3
+	case opGetLocal, opSetLocal, opGetUpvalue, opSetUpvalue, opBuildList, opBuildMap:
+		fmt.Fprintf(w, "%-16s %4d\n", op, chunk.readShort(offset+1))
+		return offset + 3
+	case opCall, opRotate:
+		fmt.Fprintf(w, "%-16s %4d\n", op, chunk.Code[offset+1])
+		return offset + 2
+	case opInvoke, opSuperInvoke:
+		constant := chunk.readShort(offset + 1)
+		fmt.Fprintf(w, "%-16s (%d args) %4d '%v'\n", op, chunk.Code[offset+3], constant, chunk.Constants[constant])
+		return offset + 4
+	case opJump, opJumpIfFalse, opTry:
+		jump := chunk.readShort(offset + 1)
+		fmt.Fprintf(w, "%-16s %4d -> %d\n", op, offset, offset+3+jump)
+		return offset + 3
+	case opLoop:
+		jump := chunk.readShort(offset + 1)
+		fmt.Fprintf(w, "%-16s %4d -> %d\n", op, offset, offset+3-jump)
+		return offset + 3
+	case opClosure:
+		constant := chunk.readShort(offset + 1)
+		function := chunk.Constants[constant].object.(*vmFunction)
+		fmt.Fprintf(w, "%-16s %4d %v\n", op, constant, function)
+		offset += 3
+		for range function.upvalueCount {
+			kind := "upvalue"
+			if chunk.Code[offset] == 1 {
+				kind = "local"
+			}
+			fmt.Fprintf(w, "%04d    |                     %s %d\n", offset, kind, chunk.readShort(offset+1))
+			offset += 3
+		}
+		return offset
+	}
+	fmt.Fprintf(w, "%s\n", op)
+	return offset + 1
+}
@@ -0,0 +1,265 @@
+package lox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	}
+	panic("Unknown Severity")
+}
+
+func (s Severity) label() string {
+	switch s {
+	case SeverityWarning:
+		return "Warning"
+	case SeverityNote:
+		return "Note"
+	}
+	return "Error"
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Code identifies the kind of problem a Diagnostic describes, so tools can
+// match on it instead of on the message text.
+type Code string
+
+const (
+	// Scanner.
+	CodeUnexpectedCharacter Code = "unexpected-character"
+	CodeUnterminatedString  Code = "unterminated-string"
+	CodeInvalidEscape       Code = "invalid-escape"
+
+	// Parser.
+	CodeSyntax              Code = "syntax"
+	CodeAssignmentCondition Code = "assignment-condition"
+
+	// Resolver.
+	CodeRedeclaration          Code = "redeclaration"
+	CodeOwnInitializer         Code = "own-initializer"
+	CodeInheritFromSelf        Code = "inherit-from-self"
+	CodeTopLevelReturn         Code = "top-level-return"
+	CodeInitializerReturn      Code = "initializer-return"
+	CodeSuperOutsideClass      Code = "super-outside-class"
+	CodeSuperWithoutSuperclass Code = "super-without-superclass"
+	CodeThisOutsideClass       Code = "this-outside-class"
+	CodeUndefinedVariable      Code = "undefined-variable"
+	CodeOutsideLoop            Code = "outside-loop"
+	CodeUndefinedLabel         Code = "undefined-label"
+
+	// Compiler, when a program is too large for the bytecode format.
+	CodeCompileLimit Code = "compile-limit"
+
+	// Interpreter.
+	CodeRuntime Code = "runtime"
+)
+
+// Span is the part of the source a Diagnostic points at. Start and End are
+// byte offsets into the source and Column is the 1-based rune column of Start.
+// Source holds the text of the line containing Start.
+type Span struct {
+	Line   int    `json:"line"`
+	Column int    `json:"column,omitempty"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	Lexeme string `json:"lexeme,omitempty"`
+	AtEnd  bool   `json:"atEnd,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+func tokenSpan(t Token) Span {
+	span := Span{Line: t.Line, Column: t.Column, Start: t.Start, End: t.End}
+	if t.file != nil {
+		span = t.file.span(t.Start, t.End)
+	}
+	span.Lexeme = t.Lexeme
+	span.AtEnd = t.Type == EOF
+	return span
+}
+
+// Note is extra context attached to a Diagnostic, such as the location of an
+// earlier declaration.
+type Note struct {
+	Message string `json:"message"`
+	Span    Span   `json:"span"`
+}
+
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     Code     `json:"code"`
+	Message  string   `json:"message"`
+	Span     Span     `json:"span"`
+	Notes    []Note   `json:"notes,omitempty"`
+	// Trace is the Lox call stack of a runtime error, innermost call first.
+	Trace []TraceFrame `json:"trace,omitempty"`
+}
+
+// String renders d the way jlox prints errors, followed by one line per note.
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	b.WriteString(d.header())
+	for _, note := range d.Notes {
+		b.WriteString("\n" + note.String())
+	}
+	return b.String()
+}
+
+func (d Diagnostic) header() string {
+	if d.Code == CodeRuntime && len(d.Trace) > 0 {
+		var b strings.Builder
+		b.WriteString(d.Message)
+		repeated := 0
+		for k, frame := range d.Trace {
+			if k > 0 && frame == d.Trace[k-1] {
+				repeated++
+				continue
+			}
+			if repeated > 0 {
+				fmt.Fprintf(&b, "\n[previous line repeated %d more times]", repeated)
+				repeated = 0
+			}
+			b.WriteString("\n" + frame.String())
+		}
+		if repeated > 0 {
+			fmt.Fprintf(&b, "\n[previous line repeated %d more times]", repeated)
+		}
+		return b.String()
+	}
+	if d.Code == CodeRuntime {
+		return fmt.Sprintf("%s\n[line %d]", d.Message, d.Span.Line)
+	}
+	return fmt.Sprintf("[line %d] %s%s: %s", d.Span.Line, d.Severity.label(), d.Span.where(), d.Message)
+}
+
+func (n Note) String() string {
+	return fmt.Sprintf("[line %d] Note%s: %s", n.Span.Line, n.Span.where(), n.Message)
+}
+
+// snippet renders the source line s points at with the span underlined, or
+// the empty string if the line isn't known.
+func (s Span) snippet() string {
+	if s.Source == "" {
+		return ""
+	}
+	gutter := strconv.Itoa(s.Line)
+	source := []rune(s.Source)
+	prefix := source[:min(s.Column-1, len(source))]
+	var marker strings.Builder
+	for _, c := range prefix {
+		if c == '\t' {
+			marker.WriteRune('\t')
+		} else {
+			marker.WriteRune(' ')
+		}
+	}
+	rest := s.Source[len(string(prefix)):]
+	width := utf8.RuneCountInString(rest[:min(s.End-s.Start, len(rest))])
+	marker.WriteString(strings.Repeat("^", max(width, 1)))
+	padding := strings.Repeat(" ", len(gutter))
+	return fmt.Sprintf(" %s | %s\n %s | %s", gutter, s.Source, padding, marker.String())
+}
+
+func (s Span) where() string {
+	if s.AtEnd {
+		return " at end"
+	}
+	if s.Lexeme != "" {
+		return " at '" + s.Lexeme + "'"
+	}
+	return ""
+}
+
+// DiagnosticSink receives diagnostics as the scanner, parser, resolver and
+// interpreter report them.
+type DiagnosticSink interface {
+	Report(d Diagnostic)
+}
+
+// DiagnosticList is a DiagnosticSink that keeps everything reported to it. Run
+// returns one as its error when the source can't be executed.
+type DiagnosticList []Diagnostic
+
+func (l *DiagnosticList) Report(d Diagnostic) {
+	*l = append(*l, d)
+}
+
+func (l DiagnosticList) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort orders the list by line, keeping the report order within a line.
+func (l DiagnosticList) Sort() {
+	slices.SortStableFunc(l, func(a, b Diagnostic) int {
+		return a.Span.Line - b.Span.Line
+	})
+}
+
+func (l DiagnosticList) Error() string {
+	lines := make([]string, len(l))
+	for i, d := range l {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RenderText writes each diagnostic in the jlox format, followed by the
+// offending source line with the span underlined.
+func RenderText(w io.Writer, diagnostics []Diagnostic) error {
+	for _, d := range diagnostics {
+		var b strings.Builder
+		b.WriteString(d.header())
+		if snippet := d.Span.snippet(); snippet != "" {
+			b.WriteString("\n" + snippet)
+		}
+		for _, note := range d.Notes {
+			b.WriteString("\n" + note.String())
+			if snippet := note.Span.snippet(); snippet != "" {
+				b.WriteString("\n" + snippet)
+			}
+		}
+		_, err := fmt.Fprintln(w, b.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderJSON writes the diagnostics as a single JSON array.
+func RenderJSON(w io.Writer, diagnostics []Diagnostic) error {
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(diagnostics)
+}
@@ -1,82 +1,91 @@
-package main
+package lox
 
-import "fmt"
+import (
+	"fmt"
+	"iter"
+	"maps"
+)
 
-type Environment struct {
-	values    map[string]any
-	enclosing *Environment
+// environment holds the variables of one scope. The global environment looks
+// its variables up by name so they can be bound late, every other scope is a
+// fixed number of slots assigned by the Resolver.
+type environment struct {
+	values    map[string]Value
+	slots     []Value
+	enclosing *environment
 }
 
-func newEnvironment(enclosing *Environment) Environment {
-	return Environment{make(map[string]any), enclosing}
+func newGlobalEnvironment() *environment {
+	return &environment{make(map[string]Value), nil, nil}
 }
 
-func (e Environment) Get(name Token) any {
+func newEnvironment(enclosing *environment, size int) *environment {
+	return &environment{nil, make([]Value, size), enclosing}
+}
+
+func (e *environment) Get(name Token) Value {
 	value, ok := e.values[name.Lexeme]
 	if ok {
 		return value
 	}
-	if e.enclosing != nil {
-		return e.enclosing.Get(name)
-	}
-	panic(RuntimeError{name, "Undefined variable '" + name.Lexeme + "'."})
-	return nil
+	panic(RuntimeError{name, undefined("variable", name.Lexeme, e.names()), nil, Value{}})
 }
 
-func (e *Environment) Assign(name Token, value any) {
+func (e *environment) Assign(name Token, value Value) {
 	_, ok := e.values[name.Lexeme]
 	if ok {
 		e.values[name.Lexeme] = value
 		return
 	}
-	if e.enclosing != nil {
-		e.enclosing.Assign(name, value)
-		return
+	panic(RuntimeError{name, undefined("variable", name.Lexeme, e.names()), nil, Value{}})
+}
+
+// names yields the name of every variable that can be looked up by name from
+// e, which are the globals.
+func (e *environment) names() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for env := e; env != nil; env = env.enclosing {
+			for name := range maps.Keys(env.values) {
+				if !yield(name) {
+					return
+				}
+			}
+		}
 	}
-	panic(RuntimeError{name, "Undefined variable '" + name.Lexeme + "'."})
 }
 
-func (e *Environment) Define(name string, value any) {
+func (e *environment) Define(name string, value Value) {
 	e.values[name] = value
 }
 
-func (e Environment) ancestor(distance int) Environment {
+func (e *environment) DefineAt(slot int, value Value) {
+	e.slots[slot] = value
+}
+
+func (e *environment) ancestor(distance int) *environment {
 	env := e
 	for i := 0; i < distance; i++ {
-		env = *env.enclosing
+		env = env.enclosing
 	}
 	return env
 }
 
-func (e Environment) GetAt(distance int, name string) any {
-	return e.ancestor(distance).values[name]
+func (e *environment) GetAt(distance int, slot int) Value {
+	return e.ancestor(distance).slots[slot]
 }
 
-func (e *Environment) AssignAt(distance int, name Token, value any) {
-	e.ancestor(distance).values[name.Lexeme] = value
+func (e *environment) AssignAt(distance int, slot int, value Value) {
+	e.ancestor(distance).slots[slot] = value
 }
 
-func (e Environment) String() string {
-	result := fmt.Sprintf("%v", e.values)
+func (e *environment) String() string {
+	result := fmt.Sprintf("%v", e.slots)
+	if e.values != nil {
+		result = fmt.Sprintf("%v", e.values)
+	}
 	if e.enclosing != nil {
 		result += " -> " + e.enclosing.String()
 	}
 	return result
 
 }
-
-func (e Environment) Equals(other Environment) bool {
-	if !isEqual(e.enclosing, other.enclosing) {
-		return false
-	}
-	for k, v := range e.values {
-		ov, ok := other.values[k]
-		if !ok {
-			return false
-		}
-		if isEqual(v, ov) {
-			return false
-		}
-	}
-	return true
-}
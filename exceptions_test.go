@@ -0,0 +1,48 @@
+package lox_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jastintime/lox"
+)
+
+func TestPreludeIgnoresOptions(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			for _, maxSteps := range []int{1, 3} {
+				var diagnostics lox.DiagnosticList
+				var stderr strings.Builder
+				lox.New(lox.Options{Backend: b.backend, MaxSteps: maxSteps, Diagnostics: &diagnostics, Stderr: &stderr})
+				if len(diagnostics) > 0 || stderr.Len() > 0 {
+					t.Errorf("MaxSteps %d: loading the prelude reported %v", maxSteps, diagnostics)
+				}
+			}
+			var output strings.Builder
+			interpreter := lox.New(lox.Options{Backend: b.backend, MaxSteps: 20, Stdout: &output})
+			err := interpreter.Run(`try { throw Error("x"); } catch (e) { print e.message; }`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != "x\n" {
+				t.Errorf("output = %q", output.String())
+			}
+		})
+	}
+}
+
+func TestNativeErrorIsCatchable(t *testing.T) {
+	setup := func(interpreter *lox.Interpreter) {
+		interpreter.DefineFunc("fail", func() error { return errors.New("it broke") })
+	}
+	source := `try { fail(); } catch (e) { print e.message; }`
+	run(t, lox.Options{}, setup, source, func(t *testing.T, output string, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != "it broke\n" {
+			t.Errorf("output = %q", output)
+		}
+	})
+}
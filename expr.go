@@ -1,119 +1,230 @@
-package main
+package lox
+
+type exprVisitor interface {
+	visitLiteralExpr(expr *literalExpr) Value
+	visitAssignExpr(expr *assignExpr) Value
+	visitUnaryExpr(expr *unaryExpr) Value
+	visitBinaryExpr(expr *binaryExpr) Value
+	visitGroupingExpr(expr *groupingExpr) Value
+	visitVariableExpr(expr *variableExpr) Value
+	visitLogicalExpr(expr *logicalExpr) Value
+	visitCallExpr(expr *callExpr) Value
+	visitGetExpr(expr *getExpr) Value
+	visitSetExpr(Expr *setExpr) Value
+	visitThisExpr(Expr *thisExpr) Value
+	visitSuperExpr(Expr *superExpr) Value
+	visitListExpr(expr *listExpr) Value
+	visitMapExpr(expr *mapExpr) Value
+	visitIndexExpr(expr *indexExpr) Value
+	visitIndexSetExpr(expr *indexSetExpr) Value
+	visitStringifyExpr(expr *stringifyExpr) Value
+	visitFunctionExpr(expr *functionExpr) Value
+	visitConditionalExpr(expr *conditionalExpr) Value
+	visitCommaExpr(expr *commaExpr) Value
+	visitUpdateExpr(expr *updateExpr) Value
+}
+
+type expr interface {
+	accept(visitor exprVisitor) Value
+}
+
+type literalExpr struct {
+	Value Value
+}
+
+type logicalExpr struct {
+	Left     expr
+	Operator Token
+	Right    expr
+}
 
-type ExprVisitor interface {
-	VisitLiteralExpr(expr LiteralExpr) any
-	VisitAssignExpr(expr AssignExpr) any
-	VisitUnaryExpr(expr UnaryExpr) any
-	VisitBinaryExpr(expr BinaryExpr) any
-	VisitGroupingExpr(expr GroupingExpr) any
-	VisitVariableExpr(expr VariableExpr) any
-	VisitLogicalExpr(expr LogicalExpr) any
-	VisitCallExpr(expr CallExpr) any
-	VisitGetExpr(expr GetExpr) any
-	VisitSetExpr(Expr SetExpr) any
-	VisitThisExpr(Expr ThisExpr) any
-	VisitSuperExpr(Expr SuperExpr) any
+type setExpr struct {
+	Object expr
+	Name   Token
+	Equals Token
+	Value  expr
 }
 
-type Expr interface {
-	Accept(visitor ExprVisitor) any
+type superExpr struct {
+	Keyword Token
+	Method  Token
+	local   *binding
 }
 
-type LiteralExpr struct {
-	Value any
+type thisExpr struct {
+	Keyword Token
+	local   *binding
 }
 
-type LogicalExpr struct {
-	Left     Expr
+type unaryExpr struct {
 	Operator Token
-	Right    Expr
+	Right    expr
 }
 
-type SetExpr struct {
-	Object Expr
+type assignExpr struct {
 	Name   Token
-	Value  Expr
+	Equals Token
+	Value  expr
+	// local is where the Resolver found the variable, nil for a global.
+	local *binding
 }
 
-type SuperExpr struct {
-	Keyword Token
-	Method  Token
+type binaryExpr struct {
+	Left     expr
+	Operator Token
+	Right    expr
 }
 
-type ThisExpr struct {
-	Keyword Token
+type callExpr struct {
+	Callee    expr
+	Paren     Token
+	Arguments []expr
 }
 
-type UnaryExpr struct {
-	Operator Token
-	Right    Expr
+type getExpr struct {
+	Object expr
+	Name   Token
+}
+
+// indexExpr is object[index]. Bracket is the closing ']', where errors about
+// the index are reported.
+type indexExpr struct {
+	Object  expr
+	Bracket Token
+	Index   expr
+}
+
+type indexSetExpr struct {
+	Object  expr
+	Bracket Token
+	Index   expr
+	Equals  Token
+	Value   expr
+}
+
+type listExpr struct {
+	Bracket  Token
+	Elements []expr
+}
+
+// mapExpr is a map literal, Keys[k] maps to Values[k]. Brace is the closing
+// '}', where an invalid key is reported.
+type mapExpr struct {
+	Brace  Token
+	Keys   []expr
+	Values []expr
+}
+
+type groupingExpr struct {
+	Expression expr
 }
 
-type AssignExpr struct {
+type variableExpr struct {
 	Name  Token
-	Value Expr
+	local *binding
 }
 
-type BinaryExpr struct {
-	Left     Expr
-	Operator Token
-	Right    Expr
+// conditionalExpr is Condition ? ThenBranch : ElseBranch.
+type conditionalExpr struct {
+	Condition  expr
+	Question   Token
+	ThenBranch expr
+	ElseBranch expr
 }
 
-type CallExpr struct {
-	Callee    Expr
-	Paren     Token
-	Arguments []Expr
+// commaExpr evaluates Left for its side effects and then Right, which is
+// its value.
+type commaExpr struct {
+	Left  expr
+	Right expr
 }
 
-type GetExpr struct {
-	Object Expr
-	Name   Token
+// updateExpr is a compound assignment like a += b, or ++ or -- with a Value
+// of 1. Target is a variableExpr, getExpr or indexExpr, whose object and index
+// are only evaluated once. Operator is the binary operator applied, with the
+// lexeme it was written as. A postfix ++ or -- is the value before the update.
+type updateExpr struct {
+	Target   expr
+	Operator Token
+	Value    expr
+	Postfix  bool
 }
 
-type GroupingExpr struct {
-	Expression Expr
+// functionExpr is an anonymous function. Function is named "anonymous" so it
+// is declared and called like any other function, but the name is never
+// defined.
+type functionExpr struct {
+	Function *functionStmt
 }
 
-type VariableExpr struct {
-	Name Token
+// stringifyExpr converts the value of an expression interpolated into a
+// string to the text print would show for it.
+type stringifyExpr struct {
+	Expression expr
 }
 
-func (b LiteralExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitLiteralExpr(b)
+func (b *literalExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitLiteralExpr(b)
 }
-func (b UnaryExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitUnaryExpr(b)
+func (b *unaryExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitUnaryExpr(b)
 }
 
-func (b BinaryExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitBinaryExpr(b)
+func (b *binaryExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitBinaryExpr(b)
 }
 
-func (b GroupingExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitGroupingExpr(b)
+func (b *groupingExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitGroupingExpr(b)
+}
+func (b *variableExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitVariableExpr(b)
+}
+func (b *conditionalExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitConditionalExpr(b)
+}
+func (b *commaExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitCommaExpr(b)
+}
+func (b *updateExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitUpdateExpr(b)
+}
+func (b *functionExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitFunctionExpr(b)
+}
+func (b *stringifyExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitStringifyExpr(b)
+}
+func (b *assignExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitAssignExpr(b)
+}
+func (b *logicalExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitLogicalExpr(b)
+}
+func (b *callExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitCallExpr(b)
 }
-func (b VariableExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitVariableExpr(b)
+func (b *getExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitGetExpr(b)
 }
-func (b AssignExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitAssignExpr(b)
+func (b *setExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitSetExpr(b)
 }
-func (b LogicalExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitLogicalExpr(b)
+func (b *thisExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitThisExpr(b)
 }
-func (b CallExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitCallExpr(b)
+func (b *superExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitSuperExpr(b)
 }
-func (b GetExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitGetExpr(b)
+func (b *listExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitListExpr(b)
 }
-func (b SetExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitSetExpr(b)
+func (b *indexExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitIndexExpr(b)
 }
-func (b ThisExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitThisExpr(b)
+func (b *indexSetExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitIndexSetExpr(b)
 }
-func (b SuperExpr) Accept(visitor ExprVisitor) any {
-	return visitor.VisitSuperExpr(b)
+func (b *mapExpr) accept(visitor exprVisitor) Value {
+	return visitor.visitMapExpr(b)
 }
@@ -1,54 +1,78 @@
-package main
+package lox
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"math"
+	"os"
 	"time"
 )
 
 type Interpreter struct {
-	Environment Environment
-	Globals     Environment
-	Locals      map[Expr]int
-}
-type ReturnValue struct {
-	Value any
-}
-
-func (r ReturnValue) Unbox() any {
-	inside := r.Value
-	switch v := inside.(type) {
-	case ReturnValue:
-		return v.Unbox()
-	}
-	return inside
-}
-
-func newInterpreter() Interpreter {
-	globals := newEnvironment(nil)
-	globals.Define("clock", Clock{})
+	environment  *environment
+	globals      *environment
+	diagnostics  DiagnosticSink
+	stdout       io.Writer
+	stderr       io.Writer
+	stdin        io.Reader
+	maxSteps     int
+	maxCallDepth int
+	execution    *execution
+	backend      Backend
+	disassemble  io.Writer
+	vm           *machine
+	// errorClass is the prelude's Error, which runtime errors are caught as
+	// instances of even if the script defines its own Error.
+	errorClass Value
+}
+
+// defaultMaxCallDepth keeps deep recursion well inside the Go stack so it is
+// reported as a Lox stack overflow instead of crashing the process.
+const defaultMaxCallDepth = 4096
+
+// binding is where the Resolver found a local variable: how many scopes out
+// from the current one it lives and which slot of that scope holds it. It is
+// kept on the node that names the variable, so it goes away with the tree.
+type binding struct {
+	depth int
+	slot  int
+}
+
+type returnValue struct {
+	Value Value
+}
+
+func newInterpreter() *Interpreter {
+	globals := newGlobalEnvironment()
 	environment := globals
-	return Interpreter{environment, globals, make(map[Expr]int)}
+	interpreter := &Interpreter{environment, globals, nil, os.Stdout, os.Stderr, os.Stdin, 0, defaultMaxCallDepth, nil, TreeWalker, nil, nil, Value{}}
+	interpreter.DefineFunc("clock", clock)
+	return interpreter
 }
 
-type Clock struct{}
-
-func (c Clock) Arity() int {
-	return 0
-}
-func (c Clock) Call(interpreter Interpreter, arguments []any) any {
+func clock() float64 {
 	return float64(time.Now().UnixMilli()) / 1000.0
 }
 
-func (c Clock) String() string {
-	return "<native fn>"
-}
-
-func (i Interpreter) Interpret(statements []Stmt) {
+// interpret executes statements that have already been resolved. It stops
+// early with an *InterruptError if ctx is done or the step budget runs out.
+func (i *Interpreter) interpret(ctx context.Context, statements []stmt) (err error) {
+	i.execution = &execution{ctx, 0, nil}
 	defer func() {
 		panicked := recover()
 		runtimeError, ok := panicked.(RuntimeError)
 		if ok {
-			emitRuntimeError(runtimeError)
+			if runtimeError.Trace == nil {
+				runtimeError.Trace = i.trace(runtimeError.Token)
+			}
+			i.report(runtimeError.Diagnostic())
+			err = runtimeError
+			return
+		}
+		interrupt, ok := panicked.(*InterruptError)
+		if ok {
+			err = interrupt
 			return
 		}
 		if panicked != nil {
@@ -58,365 +82,687 @@ func (i Interpreter) Interpret(statements []Stmt) {
 	for _, statement := range statements {
 		i.execute(statement)
 	}
+	return nil
 }
 
-func (i Interpreter) evaluate(expr Expr) any {
-	return expr.Accept(i)
+func (i *Interpreter) report(d Diagnostic) {
+	RenderText(i.stderr, []Diagnostic{d})
+	if i.diagnostics != nil {
+		i.diagnostics.Report(d)
+	}
 }
 
-func (i Interpreter) execute(stmt Stmt) {
-	stmt.Accept(i)
+func (i *Interpreter) evaluate(expr expr) Value {
+	i.step()
+	return expr.accept(i)
 }
 
-func (i Interpreter) Resolve(expr Expr, depth int) {
-	i.Locals[expr] = depth
+// execute runs stmt. The result is nil unless stmt ran a break or continue, in
+// which case it is that *breakStmt or *continueStmt, passed outwards until it
+// reaches the loop it targets.
+func (i *Interpreter) execute(stmt stmt) any {
+	i.step()
+	return stmt.accept(i)
 }
 
-func (i Interpreter) executeBlock(statements []Stmt, environment Environment) {
+// define defines name in the current environment, in the slot local gives if
+// the declaration isn't global.
+func (i *Interpreter) define(local *binding, name Token, value Value) {
+	if local != nil {
+		i.environment.DefineAt(local.slot, value)
+	} else {
+		i.environment.Define(name.Lexeme, value)
+	}
+}
+
+func (i *Interpreter) executeBlock(statements []stmt, environment *environment) any {
 	// NOTE: in java a finally was used, we could simply just
-	// do i.Environment = previous at the end of this function but what
+	// do i.environment = previous at the end of this function but what
 	// if we panic somewhere?
-	previous := i.Environment
+	previous := i.environment
 	defer func() {
-		i.Environment = previous
+		i.environment = previous
 	}()
 
-	i.Environment = environment
+	i.environment = environment
 	for _, statement := range statements {
-		i.execute(statement)
+		jump := i.execute(statement)
+		if jump != nil {
+			return jump
+		}
 	}
-
+	return nil
 }
 
-func (i Interpreter) VisitBlockStmt(stmt BlockStmt) any {
-	i.executeBlock(stmt.Statements, newEnvironment(&i.Environment))
-	return nil
+func (i *Interpreter) visitBlockStmt(stmt *blockStmt) any {
+	return i.executeBlock(stmt.Statements, newEnvironment(i.environment, stmt.size))
 }
 
-func (i Interpreter) VisitClassStmt(stmt ClassStmt) any {
-	var superclass any
+func (i *Interpreter) visitClassStmt(stmt *classStmt) any {
+	var superclass *loxClass
 	if stmt.Superclass != nil {
-		superclass = i.evaluate(stmt.Superclass)
-		_, ok := superclass.(LoxClass)
+		var ok bool
+		superclass, ok = i.evaluate(stmt.Superclass).object.(*loxClass)
 		if !ok {
-			panic(RuntimeError{stmt.Superclass.Name, "Superclass must be a class."})
+			panic(RuntimeError{stmt.Superclass.Name, "Superclass must be a class.", nil, Value{}})
 		}
 	}
-	i.Environment.Define(stmt.Name.Lexeme, nil)
+	i.define(stmt.local, stmt.Name, Value{})
 
-	if stmt.Superclass != nil {
-		old := i.Environment
-		i.Environment = newEnvironment(&old)
-		i.Environment.Define("super", superclass)
+	if superclass != nil {
+		i.environment = newEnvironment(i.environment, 1)
+		i.environment.DefineAt(0, objectValue(ClassKind, superclass))
 	}
 
-	methods := make(map[string]LoxFunction)
+	class := &loxClass{stmt.Name.Lexeme, superclass, make(map[string]*loxFunction), make(map[string]*loxFunction), make(map[string]Value)}
 	for _, method := range stmt.Methods {
-		function := newLoxFunction(method, i.Environment, method.Name.Lexeme == "init")
-		methods[method.Name.Lexeme] = function
+		function := newLoxFunction(method, i.environment, method.Name.Lexeme == "init", class)
+		class.Methods[method.Name.Lexeme] = function
 	}
-
-	var class LoxClass
-	s, ok := superclass.(LoxClass)
-	if ok {
-		class = LoxClass{stmt.Name.Lexeme, &s, methods}
-	} else {
-		class = LoxClass{stmt.Name.Lexeme, nil, methods}
+	for _, method := range stmt.StaticMethods {
+		class.StaticMethods[method.Name.Lexeme] = newLoxFunction(method, i.environment, false, class)
 	}
 
-	if ok {
-		i.Environment = *i.Environment.enclosing
+	if superclass != nil {
+		i.environment = i.environment.enclosing
 	}
-	i.Environment.Assign(stmt.Name, class)
+	i.define(stmt.local, stmt.Name, objectValue(ClassKind, class))
 	return nil
 }
 
-func (i Interpreter) VisitExprStmt(stmt ExprStmt) any {
+func (i *Interpreter) visitExprStmt(stmt *exprStmt) any {
 	i.evaluate(stmt.Expression)
 	return nil
 }
 
-func (i Interpreter) VisitFunctionStmt(stmt FunctionStmt) any {
-	function := LoxFunction{stmt, i.Environment, false}
-	i.Environment.Define(stmt.Name.Lexeme, function)
+func (i *Interpreter) visitFunctionStmt(stmt *functionStmt) any {
+	function := newLoxFunction(stmt, i.environment, false, nil)
+	i.define(stmt.local, stmt.Name, objectValue(FunctionKind, function))
 	return nil
 }
 
-func (i Interpreter) VisitIfStmt(stmt IfStmt) any {
-	if i.isTruthy(i.evaluate(stmt.Condition)) {
-		i.execute(stmt.ThenBranch)
+func (i *Interpreter) visitIfStmt(stmt *ifStmt) any {
+	if i.evaluate(stmt.Condition).IsTruthy() {
+		return i.execute(stmt.ThenBranch)
 	} else if stmt.ElseBranch != nil {
-		i.execute(stmt.ElseBranch)
+		return i.execute(stmt.ElseBranch)
 	}
 	return nil
 }
 
-func (i Interpreter) VisitPrintStmt(stmt PrintStmt) any {
-	value := i.evaluate(stmt.Expression)
-	v, ok := value.(ReturnValue)
-	if ok {
-		value = v.Unbox()
-	}
-	if value == nil {
-		fmt.Println("nil")
-	} else {
-		fmt.Println(value)
-	}
+func (i *Interpreter) visitPrintStmt(stmt *printStmt) any {
+	fmt.Fprintln(i.stdout, i.evaluate(stmt.Expression))
 	return nil
 }
 
-func (i Interpreter) VisitReturnStmt(stmt ReturnStmt) any {
-	var value any = nil
+func (i *Interpreter) visitReturnStmt(stmt *returnStmt) any {
+	var value Value
 	if stmt.Value != nil {
 		value = i.evaluate(stmt.Value)
 	}
-	panic(ReturnValue{value})
+	panic(returnValue{value})
 }
 
-func (i Interpreter) VisitVariableStmt(stmt VariableStmt) any {
-	var value any = nil
+func (i *Interpreter) visitVariableStmt(stmt *variableStmt) any {
+	var value Value
 	if stmt.Initializer != nil {
 		value = i.evaluate(stmt.Initializer)
 	}
-	i.Environment.Define(stmt.Name.Lexeme, value)
+	i.define(stmt.local, stmt.Name, value)
 	return nil
 }
 
-func (i Interpreter) VisitWhileStmt(stmt WhileStmt) any {
-	for i.isTruthy(i.evaluate(stmt.Condition)) {
-		i.execute(stmt.Body)
+func (i *Interpreter) visitWhileStmt(stmt *whileStmt) any {
+	for i.evaluate(stmt.Condition).IsTruthy() {
+		i.checkContext()
+		switch jump := i.execute(stmt.Body).(type) {
+		case *breakStmt:
+			if !targets(stmt, jump.Label) {
+				return jump
+			}
+			return nil
+		case *continueStmt:
+			if !targets(stmt, jump.Label) {
+				return jump
+			}
+		}
+		if stmt.Increment != nil {
+			i.evaluate(stmt.Increment)
+		}
 	}
 	return nil
 }
 
-func (i Interpreter) VisitAssignExpr(expr AssignExpr) any {
-	value := i.evaluate(expr.Value)
-	distance, ok := i.Locals[expr]
-
+func (i *Interpreter) visitThrowStmt(stmt *throwStmt) any {
+	value := i.evaluate(stmt.Value)
+	if value.IsNil() {
+		panic(RuntimeError{stmt.Keyword, "Can't throw nil.", nil, Value{}})
+	}
+	var fields map[string]Value
+	isError := false
+	instance, ok := value.object.(*loxInstance)
 	if ok {
-		i.Environment.AssignAt(distance, expr.Name, value)
+		fields = instance.fields
+		isError = instance.Class.isSubclassOf(i.errorClass.object.(*loxClass))
+	}
+	panic(newThrow(stmt.Keyword, value, i.trace(stmt.Keyword), fields, isError))
+}
+
+// visitTryStmt runs the finally clause however the statement is left, then
+// carries on leaving the same way. A break or continue out of the finally
+// clause abandons whatever was leaving instead. An interrupt skips it.
+func (i *Interpreter) visitTryStmt(stmt *tryStmt) (jump any) {
+	if stmt.Finally != nil {
+		depth := len(i.execution.frames)
+		defer func() {
+			panicked := recover()
+			_, interrupted := panicked.(*InterruptError)
+			if interrupted {
+				panic(panicked)
+			}
+			runtimeError, ok := panicked.(RuntimeError)
+			if ok {
+				panicked = i.unwind(runtimeError, depth)
+			}
+			finallyJump := i.execute(stmt.Finally)
+			if finallyJump != nil {
+				jump = finallyJump
+				return
+			}
+			if panicked != nil {
+				panic(panicked)
+			}
+		}()
+	}
+	return i.executeTry(stmt)
+}
+
+// executeTry runs the body of a try statement and its catch clause if a
+// RuntimeError is raised in it.
+func (i *Interpreter) executeTry(stmt *tryStmt) (jump any) {
+	if stmt.Catch == nil {
+		return i.execute(stmt.Body)
+	}
+	depth := len(i.execution.frames)
+	defer func() {
+		panicked := recover()
+		runtimeError, ok := panicked.(RuntimeError)
+		if !ok {
+			if panicked != nil {
+				panic(panicked)
+			}
+			return
+		}
+		runtimeError = i.unwind(runtimeError, depth)
+		environment := newEnvironment(i.environment, stmt.Catch.size)
+		environment.DefineAt(0, i.exception(runtimeError))
+		jump = i.executeBlock(stmt.Catch.Statements, environment)
+	}()
+	return i.execute(stmt.Body)
+}
+
+// unwind drops the frames of the calls err is leaving, which have to be
+// recorded in its trace first.
+func (i *Interpreter) unwind(err RuntimeError, depth int) RuntimeError {
+	if err.Trace == nil {
+		err.Trace = i.trace(err.Token)
+	}
+	i.execution.frames = i.execution.frames[:depth]
+	return err
+}
+
+// exception is the value a catch clause receives for err: whatever was
+// thrown, or an Error describing a runtime error.
+func (i *Interpreter) exception(err RuntimeError) Value {
+	if !err.Value.IsNil() {
+		return err.Value
+	}
+	return objectValue(InstanceKind, &loxInstance{i.errorClass.object.(*loxClass), err.errorFields()})
+}
+
+func (i *Interpreter) visitBreakStmt(stmt *breakStmt) any {
+	return stmt
+}
+
+func (i *Interpreter) visitContinueStmt(stmt *continueStmt) any {
+	return stmt
+}
+
+// targets reports whether a break or continue with label is meant for loop.
+func targets(loop *whileStmt, label *Token) bool {
+	return label == nil || loop.Label != nil && loop.Label.Lexeme == label.Lexeme
+}
+
+func (i *Interpreter) visitAssignExpr(expr *assignExpr) Value {
+	value := i.evaluate(expr.Value)
+	if expr.local != nil {
+		i.environment.AssignAt(expr.local.depth, expr.local.slot, value)
 	} else {
-		i.Globals.Assign(expr.Name, value)
+		i.globals.Assign(expr.Name, value)
 	}
 	return value
 }
 
-func (i Interpreter) VisitBinaryExpr(expr BinaryExpr) any {
-	// NOTE: Scuffed, but anything that panics here is handled
-	// just don't want to write a bunch of stuff to push it up.
-
+func (i *Interpreter) visitBinaryExpr(expr *binaryExpr) Value {
 	left := i.evaluate(expr.Left)
 	right := i.evaluate(expr.Right)
-	switch expr.Operator.Type {
+	return i.binary(expr.Operator, left, right)
+}
+
+// binary applies a binary operator, or the one a compound assignment stands
+// for, to its operands.
+func (i *Interpreter) binary(operator Token, left Value, right Value) Value {
+	switch operator.Type {
 	case Greater:
-		i.checkNumberOperands(expr.Operator, left, right)
-		return left.(float64) > right.(float64)
+		i.checkNumberOperands(operator, left, right)
+		return BoolValue(left.number > right.number)
 	case GreaterEqual:
-		i.checkNumberOperands(expr.Operator, left, right)
-		return left.(float64) >= right.(float64)
+		i.checkNumberOperands(operator, left, right)
+		return BoolValue(left.number >= right.number)
 	case Less:
-		i.checkNumberOperands(expr.Operator, left, right)
-		return left.(float64) < right.(float64)
+		i.checkNumberOperands(operator, left, right)
+		return BoolValue(left.number < right.number)
 	case LessEqual:
-		i.checkNumberOperands(expr.Operator, left, right)
-		return left.(float64) <= right.(float64)
+		i.checkNumberOperands(operator, left, right)
+		return BoolValue(left.number <= right.number)
 	case Minus:
-		i.checkNumberOperands(expr.Operator, left, right)
-		return left.(float64) - right.(float64)
+		i.checkNumberOperands(operator, left, right)
+		return NumberValue(left.number - right.number)
 	case BangEqual:
-		return !isEqual(left, right)
+		return BoolValue(!left.Equal(right))
 	case EqualEqual:
-		return isEqual(left, right)
+		return BoolValue(left.Equal(right))
 	case Plus:
-		leftValue, isLeftDouble := left.(float64)
-		rightValue, isRightDouble := right.(float64)
-		if isLeftDouble && isRightDouble {
-			return leftValue + rightValue
+		if left.kind == NumberKind && right.kind == NumberKind {
+			return NumberValue(left.number + right.number)
 		}
-		leftString, isLeftString := left.(string)
-		rightString, isRightString := right.(string)
-		if isLeftString && isRightString {
-			return leftString + rightString
+		if left.kind == StringKind && right.kind == StringKind {
+			return StringValue(left.str + right.str)
 		}
-		panic(RuntimeError{expr.Operator, "Operands must be two numbers or two strings."})
+		panic(RuntimeError{operator, "Operands must be two numbers or two strings.", nil, Value{}})
 	case Slash:
-		i.checkNumberOperands(expr.Operator, left, right)
-		if right.(float64) == 0 {
+		i.checkNumberOperands(operator, left, right)
+		if right.number == 0 {
 			// one of the challenges
-			panic(RuntimeError{expr.Operator, "division by zero! panic"})
+			panic(RuntimeError{operator, "division by zero! panic", nil, Value{}})
 		}
-		return left.(float64) / right.(float64)
+		return NumberValue(left.number / right.number)
 	case Star:
-		i.checkNumberOperands(expr.Operator, left, right)
-		return left.(float64) * right.(float64)
+		i.checkNumberOperands(operator, left, right)
+		return NumberValue(left.number * right.number)
+	case Percent:
+		i.checkNumberOperands(operator, left, right)
+		if right.number == 0 {
+			panic(RuntimeError{operator, "division by zero! panic", nil, Value{}})
+		}
+		return NumberValue(math.Mod(left.number, right.number))
+	case StarStar:
+		i.checkNumberOperands(operator, left, right)
+		return NumberValue(math.Pow(left.number, right.number))
+	case Ampersand, Pipe, Caret, LessLess, GreaterGreater:
+		a, b, err := integerOperands(operator, left, right)
+		if err != nil {
+			panic(err)
+		}
+		switch operator.Type {
+		case Ampersand:
+			return NumberValue(float64(a & b))
+		case Pipe:
+			return NumberValue(float64(a | b))
+		case Caret:
+			return NumberValue(float64(a ^ b))
+		case LessLess:
+			return NumberValue(float64(a << b))
+		}
+		return NumberValue(float64(a >> b))
 	}
 
 	// Unreachable
-	return nil
+	return Value{}
 }
 
-func (i Interpreter) VisitCallExpr(expr CallExpr) any {
+func (i *Interpreter) visitCallExpr(expr *callExpr) Value {
 	callee := i.evaluate(expr.Callee)
-	var arguments []any
+	var arguments []Value
 	for _, argument := range expr.Arguments {
 		arguments = append(arguments, i.evaluate(argument))
 	}
-	function, ok := callee.(LoxCallable)
+	return i.call(callee, arguments, expr.Paren)
+}
+
+// call calls callee with arguments. paren is the closing paren of the call,
+// where errors about the call itself are reported.
+func (i *Interpreter) call(callee Value, arguments []Value, paren Token) Value {
+	builtin, ok := callee.object.(*builtinMethod)
+	if ok {
+		return i.callBuiltin(builtin, arguments, paren)
+	}
+	function, ok := callee.object.(loxCallable)
 	if !ok {
-		panic(RuntimeError{expr.Paren, "Can only call functions and classes."})
-		return nil
+		panic(RuntimeError{paren, "Can only call functions and classes.", nil, Value{}})
 	}
 	if len(arguments) != function.Arity() {
-		panic(RuntimeError{expr.Paren, fmt.Sprintf("Expected %d arguments but got %d.", function.Arity(), len(arguments))})
-		return nil
+		panic(RuntimeError{paren, fmt.Sprintf("Expected %d arguments but got %d.", function.Arity(), len(arguments)), nil, Value{}})
+	}
+	i.pushFrame(newStackFrame(function, paren))
+	var result Value
+	native, ok := function.(*nativeFunction)
+	if ok {
+		var err error
+		result, err = native.call(arguments)
+		if err != nil {
+			panic(nativeError(paren, err))
+		}
+	} else {
+		result = function.Call(i, arguments)
+	}
+	i.execution.frames = i.execution.frames[:len(i.execution.frames)-1]
+	return result
+}
+
+// callBuiltin calls a method of a built-in type. Functions it calls back are
+// called like any other call at paren.
+func (i *Interpreter) callBuiltin(method *builtinMethod, arguments []Value, paren Token) Value {
+	err := method.checkArity(len(arguments), paren)
+	if err != nil {
+		panic(err)
+	}
+	i.pushFrame(stackFrame{method.name, method.class, true, paren})
+	callback := func(callee Value, arguments []Value) (Value, error) {
+		return i.call(callee, arguments, paren), nil
+	}
+	result, err := method.fn(builtinCall{arguments, paren, callback})
+	if err != nil {
+		panic(err)
+	}
+	i.execution.frames = i.execution.frames[:len(i.execution.frames)-1]
+	return result
+}
+
+// pushFrame records a call on the stack. A frame is only popped when the call
+// returns normally, so the stack is still there to build a trace from when a
+// runtime error reaches interpret.
+func (i *Interpreter) pushFrame(frame stackFrame) {
+	if len(i.execution.frames) >= i.maxCallDepth {
+		panic(RuntimeError{frame.call, "Stack overflow.", nil, Value{}})
 	}
-	return function.Call(i, arguments)
+	i.execution.frames = append(i.execution.frames, frame)
+}
+
+// stackFrame is a call the interpreter is executing and the token it was
+// called from.
+type stackFrame struct {
+	function string
+	class    string
+	native   bool
+	call     Token
 }
 
-func (i Interpreter) VisitGetExpr(expr GetExpr) any {
+func newStackFrame(callable loxCallable, call Token) stackFrame {
+	switch callable := callable.(type) {
+	case *loxFunction:
+		frame := stackFrame{function: callable.Declaration.Name.Lexeme, call: call}
+		if callable.class != nil {
+			frame.class = callable.class.Name
+		}
+		return frame
+	case *loxClass:
+		return stackFrame{function: callable.Name, call: call}
+	case *nativeFunction:
+		return stackFrame{function: callable.name, native: true, call: call}
+	}
+	return stackFrame{function: fmt.Sprint(callable), call: call}
+}
+
+// trace turns the frames still on the stack into a TraceFrame per call, plus
+// one for the script itself. at is where the innermost frame failed.
+func (i *Interpreter) trace(at Token) []TraceFrame {
+	frames := i.execution.frames
+	trace := make([]TraceFrame, 0, len(frames)+1)
+	line := at.Line
+	for k := len(frames) - 1; k >= 0; k-- {
+		frame := frames[k]
+		trace = append(trace, TraceFrame{frame.function, frame.class, line, frame.native})
+		line = frame.call.Line
+	}
+	return append(trace, TraceFrame{Line: line})
+}
+
+func (i *Interpreter) visitGetExpr(expr *getExpr) Value {
+	return i.getProperty(i.evaluate(expr.Object), expr.Name)
+}
+
+func (i *Interpreter) getProperty(object Value, name Token) Value {
+	switch object := object.object.(type) {
+	case *loxInstance:
+		return object.Get(name)
+	case *loxClass:
+		return object.Get(name)
+	case builtinType:
+		method, err := object.method(name.Lexeme, name)
+		if err != nil {
+			panic(err)
+		}
+		return objectValue(NativeKind, method)
+	}
+	panic(RuntimeError{name, "Only instances have properties.", nil, Value{}})
+}
+
+func (i *Interpreter) visitIndexExpr(expr *indexExpr) Value {
 	object := i.evaluate(expr.Object)
-	value, ok := object.(LoxInstance)
-	if ok {
-		return value.Get(expr.Name)
+	index := i.evaluate(expr.Index)
+	value, err := getIndex(object, index, expr.Bracket)
+	if err != nil {
+		panic(err)
 	}
-	panic(RuntimeError{expr.Name, "Only instances have properties."})
-	return nil
+	return value
 }
 
-func (i Interpreter) VisitGroupingExpr(expr GroupingExpr) any {
+func (i *Interpreter) visitIndexSetExpr(expr *indexSetExpr) Value {
+	object := i.evaluate(expr.Object)
+	index := i.evaluate(expr.Index)
+	value := i.evaluate(expr.Value)
+	err := setIndex(object, index, value, expr.Bracket)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+func (i *Interpreter) visitListExpr(expr *listExpr) Value {
+	elements := make([]Value, len(expr.Elements))
+	for k, element := range expr.Elements {
+		elements[k] = i.evaluate(element)
+	}
+	return objectValue(ListKind, newLoxList(elements))
+}
+
+// visitMapExpr evaluates every entry before building the map, so a bad key is
+// reported after the same side effects as on the VM.
+func (i *Interpreter) visitMapExpr(expr *mapExpr) Value {
+	keys := make([]Value, len(expr.Keys))
+	values := make([]Value, len(expr.Values))
+	for k := range expr.Keys {
+		keys[k] = i.evaluate(expr.Keys[k])
+		values[k] = i.evaluate(expr.Values[k])
+	}
+	m := newLoxMap()
+	for k := range keys {
+		err := m.set(keys[k], values[k], expr.Brace)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return objectValue(MapKind, m)
+}
+
+func (i *Interpreter) visitGroupingExpr(expr *groupingExpr) Value {
 	return i.evaluate(expr.Expression)
 }
 
-func (i Interpreter) VisitLiteralExpr(expr LiteralExpr) any {
+// visitConditionalExpr only evaluates the branch that is chosen.
+func (i *Interpreter) visitConditionalExpr(expr *conditionalExpr) Value {
+	if i.evaluate(expr.Condition).IsTruthy() {
+		return i.evaluate(expr.ThenBranch)
+	}
+	return i.evaluate(expr.ElseBranch)
+}
+
+func (i *Interpreter) visitCommaExpr(expr *commaExpr) Value {
+	i.evaluate(expr.Left)
+	return i.evaluate(expr.Right)
+}
+
+// visitUpdateExpr evaluates the object and index of the target once, then
+// reads the target, applies the operator and assigns the result.
+func (i *Interpreter) visitUpdateExpr(expr *updateExpr) Value {
+	var old, value Value
+	switch target := expr.Target.(type) {
+	case *variableExpr:
+		old = i.lookupVariable(target.Name, target.local)
+		value = i.binary(expr.Operator, old, i.evaluate(expr.Value))
+		if target.local != nil {
+			i.environment.AssignAt(target.local.depth, target.local.slot, value)
+		} else {
+			i.globals.Assign(target.Name, value)
+		}
+	case *getExpr:
+		object := i.evaluate(target.Object)
+		old = i.getProperty(object, target.Name)
+		value = i.binary(expr.Operator, old, i.evaluate(expr.Value))
+		i.fieldsOf(object, target.Name).Set(target.Name, value)
+	case *indexExpr:
+		object := i.evaluate(target.Object)
+		index := i.evaluate(target.Index)
+		var err error
+		old, err = getIndex(object, index, target.Bracket)
+		if err != nil {
+			panic(err)
+		}
+		value = i.binary(expr.Operator, old, i.evaluate(expr.Value))
+		err = setIndex(object, index, value, target.Bracket)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if expr.Postfix {
+		return old
+	}
+	return value
+}
+
+func (i *Interpreter) visitFunctionExpr(expr *functionExpr) Value {
+	return objectValue(FunctionKind, newLoxFunction(expr.Function, i.environment, false, nil))
+}
+
+func (i *Interpreter) visitStringifyExpr(expr *stringifyExpr) Value {
+	value := i.evaluate(expr.Expression)
+	if value.kind == StringKind {
+		return value
+	}
+	return StringValue(value.String())
+}
+
+func (i *Interpreter) visitLiteralExpr(expr *literalExpr) Value {
 	return expr.Value
 }
 
-func (i Interpreter) VisitLogicalExpr(expr LogicalExpr) any {
+func (i *Interpreter) visitLogicalExpr(expr *logicalExpr) Value {
 	left := i.evaluate(expr.Left)
 	if expr.Operator.Type == Or {
-		if i.isTruthy(left) {
+		if left.IsTruthy() {
 			return left
 		}
 	}
 	if expr.Operator.Type == And {
-		if !i.isTruthy(left) {
+		if !left.IsTruthy() {
 			return left
 		}
 	}
 	return i.evaluate(expr.Right)
 }
 
-func (i Interpreter) VisitSetExpr(expr SetExpr) any {
-	object := i.evaluate(expr.Object)
-	o, ok := object.(LoxInstance)
-	if !ok {
-		panic(RuntimeError{expr.Name, "Only instances have fields."})
-	}
+func (i *Interpreter) visitSetExpr(expr *setExpr) Value {
+	object := i.fieldsOf(i.evaluate(expr.Object), expr.Name)
 	value := i.evaluate(expr.Value)
-	o.Set(expr.Name, value)
+	object.Set(expr.Name, value)
 	return value
 }
 
-func (i Interpreter) VisitSuperExpr(expr SuperExpr) any {
-	distance := i.Locals[expr]
-	superclass, _ := i.Environment.GetAt(distance, "super").(LoxClass)
-	object, _ := i.Environment.GetAt(distance-1, "this").(LoxInstance)
+// fieldOwner is a value with fields, an instance or a class.
+type fieldOwner interface {
+	Set(name Token, value Value)
+}
+
+// fieldsOf returns object as a fieldOwner, for setting the field at name.
+func (i *Interpreter) fieldsOf(object Value, name Token) fieldOwner {
+	switch object := object.object.(type) {
+	case *loxInstance:
+		return object
+	case *loxClass:
+		return object
+	}
+	panic(RuntimeError{name, "Only instances have fields.", nil, Value{}})
+}
+
+func (i *Interpreter) visitSuperExpr(expr *superExpr) Value {
+	local := expr.local
+	superclass := i.environment.GetAt(local.depth, local.slot).object.(*loxClass)
+	this := i.environment.GetAt(local.depth-1, 0)
+	if this.kind == ClassKind {
+		// super in a static method finds the superclass's static methods.
+		method, exist := superclass.findStaticMethod(expr.Method.Lexeme)
+		if !exist {
+			panic(RuntimeError{expr.Method, undefined("property", expr.Method.Lexeme, superclass.staticMethodNames()), nil, Value{}})
+		}
+		return objectValue(FunctionKind, method.bind(this))
+	}
 	method, exist := superclass.FindMethod(expr.Method.Lexeme)
 	if !exist {
-		panic(RuntimeError{expr.Method, "Undefined property '" + expr.Method.Lexeme + "'."})
+		panic(RuntimeError{expr.Method, undefined("property", expr.Method.Lexeme, superclass.methodNames()), nil, Value{}})
 	}
-	return method.Bind(object)
+	return objectValue(FunctionKind, method.Bind(this.object.(*loxInstance)))
 }
 
-func (i Interpreter) VisitThisExpr(expr ThisExpr) any {
-	return i.lookupVariable(expr.Keyword, expr)
+func (i *Interpreter) visitThisExpr(expr *thisExpr) Value {
+	return i.lookupVariable(expr.Keyword, expr.local)
 }
 
-func (i Interpreter) VisitUnaryExpr(expr UnaryExpr) any {
+func (i *Interpreter) visitUnaryExpr(expr *unaryExpr) Value {
 	right := i.evaluate(expr.Right)
 	switch expr.Operator.Type {
 	case Bang:
-		return !i.isTruthy(right)
+		return BoolValue(!right.IsTruthy())
 	case Minus:
 		i.checkNumberOperand(expr.Operator, right)
-		return -right.(float64)
+		return NumberValue(-right.number)
 	}
 	//unreachable
-	return nil
+	return Value{}
 }
 
-func (i Interpreter) VisitVariableExpr(expr VariableExpr) any {
-	return i.lookupVariable(expr.Name, expr)
+func (i *Interpreter) visitVariableExpr(expr *variableExpr) Value {
+	return i.lookupVariable(expr.Name, expr.local)
 }
 
-func (i Interpreter) lookupVariable(name Token, expr Expr) any {
-	distance, ok := i.Locals[expr]
-	if ok {
-		return i.Environment.GetAt(distance, name.Lexeme)
+func (i *Interpreter) lookupVariable(name Token, local *binding) Value {
+	if local != nil {
+		return i.environment.GetAt(local.depth, local.slot)
 	}
-	return i.Globals.Get(name)
+	return i.globals.Get(name)
 
 }
 
-func (i Interpreter) checkNumberOperand(operator Token, operand any) {
-	_, ok := operand.(float64)
-	if ok {
+func (i *Interpreter) checkNumberOperand(operator Token, operand Value) {
+	if operand.kind == NumberKind {
 		return
 	}
-	panic(RuntimeError{operator, "Operand must be a number."})
+	panic(RuntimeError{operator, "Operand must be a number.", nil, Value{}})
 }
 
-func (i Interpreter) checkNumberOperands(operator Token, left any, right any) {
-	_, okleft := left.(float64)
-	_, okright := right.(float64)
-	if okleft && okright {
+func (i *Interpreter) checkNumberOperands(operator Token, left Value, right Value) {
+	if left.kind == NumberKind && right.kind == NumberKind {
 		return
 	}
-	panic(RuntimeError{operator, "Operands must be numbers."})
-	return
-}
-
-func (i Interpreter) isTruthy(object any) bool {
-	if object == nil {
-		return false
-	}
-	value, ok := object.(bool)
-	if ok {
-		return value
-	}
-	return true
-}
-
-func isEqual(a any, b any) (ret bool) {
-	//NOTE: This function is neccesary because we can't compare maps in go by default
-	// we need to use the maps.Equal function or else we panic
-	defer func() {
-		panicked := recover()
-		if panicked != nil {
-			class1, ok := a.(LoxClass)
-			class2, ok2 := b.(LoxClass)
-			//
-			if ok && ok2 {
-				ret = class1.Equals(class2)
-				return
-			}
-			fun1, ok3 := a.(LoxFunction)
-			fun2, ok4 := b.(LoxFunction)
-			if ok3 && ok4 {
-				ret = fun1.Equals(fun2)
-				return
-			}
-			instance1, ok5 := a.(LoxInstance)
-			instance2, ok6 := a.(LoxInstance)
-			if ok5 && ok6 {
-				ret = instance1.Equals(instance2)
-				return
-			}
-
-			panic(panicked)
-		}
-	}()
-
-	return a == b
-
+	panic(RuntimeError{operator, "Operands must be numbers.", nil, Value{}})
 }
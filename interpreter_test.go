@@ -0,0 +1,28 @@
+package lox_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jastintime/lox"
+)
+
+func TestMaxCallDepth(t *testing.T) {
+	source := `
+fun depth(n) {
+  if (n == 0) return "done";
+  return depth(n - 1);
+}
+print depth(40);
+print depth(60);
+`
+	run(t, lox.Options{MaxCallDepth: 50}, nil, source, func(t *testing.T, output string, err error) {
+		var runtimeError lox.RuntimeError
+		if !errors.As(err, &runtimeError) || runtimeError.Message != "Stack overflow." {
+			t.Fatalf("Run returned %v, want a stack overflow", err)
+		}
+		if output != "done\n" {
+			t.Errorf("output = %q", output)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package lox
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrStepBudgetExhausted is the cause of an InterruptError when a run takes
+// more steps than Options.MaxSteps allows.
+var ErrStepBudgetExhausted = errors.New("step budget exhausted")
+
+// InterruptError is returned when execution is stopped before the script
+// finishes, either because the context passed to RunContext was cancelled or
+// timed out, or because the step budget ran out. Cause is ctx.Err() or
+// ErrStepBudgetExhausted, so errors.Is works with either.
+type InterruptError struct {
+	Cause error
+}
+
+func (e *InterruptError) Error() string {
+	return "lox: execution interrupted: " + e.Cause.Error()
+}
+
+func (e *InterruptError) Unwrap() error {
+	return e.Cause
+}
+
+// execution is the state of a single call to interpret.
+type execution struct {
+	ctx    context.Context
+	steps  int
+	frames []stackFrame
+}
+
+// step counts one statement executed or expression evaluated against the
+// budget and stops the run if the budget is spent or the context is done.
+func (i *Interpreter) step() {
+	i.execution.steps++
+	if i.maxSteps > 0 && i.execution.steps > i.maxSteps {
+		panic(&InterruptError{ErrStepBudgetExhausted})
+	}
+	i.checkContext()
+}
+
+func (i *Interpreter) checkContext() {
+	select {
+	case <-i.execution.ctx.Done():
+		panic(&InterruptError{i.execution.ctx.Err()})
+	default:
+	}
+}
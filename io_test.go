@@ -0,0 +1,53 @@
+package lox_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jastintime/lox"
+)
+
+func TestStderrAndDiagnostics(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			var diagnostics lox.DiagnosticList
+			interpreter := lox.New(lox.Options{Backend: b.backend, Stdout: &stdout, Stderr: &stderr, Diagnostics: &diagnostics})
+			if err := interpreter.Run(`print 1; print -"a";`); err == nil {
+				t.Fatal("Run succeeded")
+			}
+			if stdout.String() != "1\n" {
+				t.Errorf("stdout = %q", stdout.String())
+			}
+			if !strings.Contains(stderr.String(), "Operand must be a number.") {
+				t.Errorf("stderr = %q", stderr.String())
+			}
+			if len(diagnostics) != 1 || diagnostics[0].Message != "Operand must be a number." {
+				t.Errorf("diagnostics = %v", diagnostics)
+			}
+		})
+	}
+}
+
+func TestRunPrompt(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			interpreter := lox.New(lox.Options{
+				Backend: b.backend,
+				Stdin:   strings.NewReader("var a = 1;\nprint b;\nprint a + 1;"),
+				Stdout:  &stdout,
+				Stderr:  &stderr,
+			})
+			if err := interpreter.RunPrompt(); err != nil {
+				t.Fatal(err)
+			}
+			if want := "> > > 2\n\n"; stdout.String() != want {
+				t.Errorf("stdout = %q, want %q", stdout.String(), want)
+			}
+			if !strings.Contains(stderr.String(), "Undefined variable 'b'.") {
+				t.Errorf("stderr = %q", stderr.String())
+			}
+		})
+	}
+}
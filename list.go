@@ -0,0 +1,284 @@
+package lox
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// LoxList is a list created by a list literal or one of the list methods.
+// Both backends use it.
+type LoxList struct {
+	elements []Value
+}
+
+func newLoxList(elements []Value) *LoxList {
+	return &LoxList{elements}
+}
+
+// NewList returns a list holding elements, for a native to return.
+func NewList(elements ...Value) *LoxList {
+	return newLoxList(slices.Clone(elements))
+}
+
+// Len returns the number of elements in the list.
+func (l *LoxList) Len() int {
+	return len(l.elements)
+}
+
+// At returns the element at index, which must be in [0, Len()).
+func (l *LoxList) At(index int) Value {
+	return l.elements[index]
+}
+
+// Append adds elements to the end of the list.
+func (l *LoxList) Append(elements ...Value) {
+	l.elements = append(l.elements, elements...)
+}
+
+func (l *LoxList) String() string {
+	return elementString(objectValue(ListKind, l))
+}
+
+// index converts a Lox index to a position in the list, counting back from
+// the end when it is negative. Positions up to size are valid, which is the
+// length of the list for everything but insert.
+func (l *LoxList) index(index Value, size int, token Token) (int, error) {
+	if index.kind != NumberKind || index.number != math.Trunc(index.number) {
+		return 0, RuntimeError{token, "List index must be an integer.", nil, Value{}}
+	}
+	position := index.number
+	if position < 0 {
+		position += float64(len(l.elements))
+	}
+	if position < 0 || position >= float64(size) {
+		message := fmt.Sprintf("Index %v is out of bounds for a list of length %d.", index, len(l.elements))
+		return 0, RuntimeError{token, message, nil, Value{}}
+	}
+	return int(position), nil
+}
+
+func (l *LoxList) get(index Value, bracket Token) (Value, error) {
+	position, err := l.index(index, len(l.elements), bracket)
+	if err != nil {
+		return Value{}, err
+	}
+	return l.elements[position], nil
+}
+
+func (l *LoxList) set(index Value, value Value, bracket Token) error {
+	position, err := l.index(index, len(l.elements), bracket)
+	if err != nil {
+		return err
+	}
+	l.elements[position] = value
+	return nil
+}
+
+// listMethod is one of the methods every list has.
+type listMethod struct {
+	minArity int
+	maxArity int
+	fn       func(l *LoxList, call builtinCall) (Value, error)
+}
+
+var listMethods = map[string]listMethod{
+	"filter": {1, 1, (*LoxList).filter},
+	"insert": {2, 2, (*LoxList).insert},
+	"join":   {1, 1, (*LoxList).join},
+	"len":    {0, 0, (*LoxList).len},
+	"map":    {1, 1, (*LoxList).map_},
+	"pop":    {0, 0, (*LoxList).pop},
+	"push":   {1, 1, (*LoxList).push},
+	"reduce": {2, 2, (*LoxList).reduce},
+	"remove": {1, 1, (*LoxList).remove},
+	"slice":  {1, 2, (*LoxList).slice},
+	"sort":   {0, 1, (*LoxList).sort},
+}
+
+// method returns the list method called name bound to l.
+func (l *LoxList) method(name string, token Token) (*builtinMethod, error) {
+	method, ok := listMethods[name]
+	if !ok {
+		return nil, RuntimeError{token, undefined("property", name, maps.Keys(listMethods)), nil, Value{}}
+	}
+	fn := func(call builtinCall) (Value, error) {
+		return method.fn(l, call)
+	}
+	return &builtinMethod{"list", name, method.minArity, method.maxArity, fn}, nil
+}
+
+func (l *LoxList) push(call builtinCall) (Value, error) {
+	l.elements = append(l.elements, call.arguments[0])
+	return Value{}, nil
+}
+
+func (l *LoxList) pop(call builtinCall) (Value, error) {
+	if len(l.elements) == 0 {
+		return Value{}, RuntimeError{call.paren, "Can't pop from an empty list.", nil, Value{}}
+	}
+	value := l.elements[len(l.elements)-1]
+	l.elements = l.elements[:len(l.elements)-1]
+	return value, nil
+}
+
+// insert puts a value before the element at index, or at the end if index is
+// the length of the list.
+func (l *LoxList) insert(call builtinCall) (Value, error) {
+	position, err := l.index(call.arguments[0], len(l.elements)+1, call.paren)
+	if err != nil {
+		return Value{}, err
+	}
+	l.elements = slices.Insert(l.elements, position, call.arguments[1])
+	return Value{}, nil
+}
+
+// remove takes the element at index out of the list and returns it.
+func (l *LoxList) remove(call builtinCall) (Value, error) {
+	position, err := l.index(call.arguments[0], len(l.elements), call.paren)
+	if err != nil {
+		return Value{}, err
+	}
+	value := l.elements[position]
+	l.elements = slices.Delete(l.elements, position, position+1)
+	return value, nil
+}
+
+func (l *LoxList) len(call builtinCall) (Value, error) {
+	return NumberValue(float64(len(l.elements))), nil
+}
+
+// slice returns a new list of the elements from start up to but not including
+// end, which defaults to the end of the list. Both may be negative and are
+// clamped to the list rather than being out of bounds.
+func (l *LoxList) slice(call builtinCall) (Value, error) {
+	start, err := l.bound(call.arguments[0], call.paren)
+	if err != nil {
+		return Value{}, err
+	}
+	end := len(l.elements)
+	if len(call.arguments) > 1 {
+		end, err = l.bound(call.arguments[1], call.paren)
+		if err != nil {
+			return Value{}, err
+		}
+	}
+	end = max(start, end)
+	return objectValue(ListKind, newLoxList(slices.Clone(l.elements[start:end]))), nil
+}
+
+// bound converts an index used as one end of a slice to a position between 0
+// and the length of the list.
+func (l *LoxList) bound(index Value, paren Token) (int, error) {
+	if index.kind != NumberKind || index.number != math.Trunc(index.number) {
+		return 0, RuntimeError{paren, "List index must be an integer.", nil, Value{}}
+	}
+	position := index.number
+	if position < 0 {
+		position += float64(len(l.elements))
+	}
+	return int(min(max(position, 0), float64(len(l.elements)))), nil
+}
+
+// sort sorts the list in place. Without a comparator the elements must be
+// all numbers or all strings. A comparator is called with two elements and
+// returns a negative number if the first goes first, a positive one if it
+// goes second and zero to keep their order.
+func (l *LoxList) sort(call builtinCall) (Value, error) {
+	elements := slices.Clone(l.elements)
+	var err error
+	var compare func(a, b Value) int
+	if len(call.arguments) == 0 {
+		for _, element := range elements {
+			if element.kind != elements[0].kind || element.kind != NumberKind && element.kind != StringKind {
+				return Value{}, RuntimeError{call.paren, "Can only sort numbers or strings without a comparator.", nil, Value{}}
+			}
+		}
+		compare = func(a, b Value) int {
+			if a.kind == NumberKind {
+				return cmp.Compare(a.number, b.number)
+			}
+			return strings.Compare(a.str, b.str)
+		}
+	} else {
+		compare = func(a, b Value) int {
+			if err != nil {
+				return 0
+			}
+			var result Value
+			result, err = call.callback(call.arguments[0], []Value{a, b})
+			if err == nil && result.kind != NumberKind {
+				err = RuntimeError{call.paren, "Comparator must return a number.", nil, Value{}}
+			}
+			return cmp.Compare(result.number, 0)
+		}
+	}
+	slices.SortStableFunc(elements, compare)
+	if err != nil {
+		return Value{}, err
+	}
+	l.elements = elements
+	return Value{}, nil
+}
+
+// map_ returns a new list of the results of calling a function on each
+// element.
+func (l *LoxList) map_(call builtinCall) (Value, error) {
+	mapped := make([]Value, 0, len(l.elements))
+	for k := 0; k < len(l.elements); k++ {
+		value, err := call.callback(call.arguments[0], []Value{l.elements[k]})
+		if err != nil {
+			return Value{}, err
+		}
+		mapped = append(mapped, value)
+	}
+	return objectValue(ListKind, newLoxList(mapped)), nil
+}
+
+// filter returns a new list of the elements a function returns a truthy value
+// for.
+func (l *LoxList) filter(call builtinCall) (Value, error) {
+	var filtered []Value
+	for k := 0; k < len(l.elements); k++ {
+		element := l.elements[k]
+		keep, err := call.callback(call.arguments[0], []Value{element})
+		if err != nil {
+			return Value{}, err
+		}
+		if keep.IsTruthy() {
+			filtered = append(filtered, element)
+		}
+	}
+	return objectValue(ListKind, newLoxList(filtered)), nil
+}
+
+// reduce folds the list into one value, starting from an initial value and
+// calling a function with the value so far and each element in turn.
+func (l *LoxList) reduce(call builtinCall) (Value, error) {
+	accumulator := call.arguments[1]
+	for k := 0; k < len(l.elements); k++ {
+		var err error
+		accumulator, err = call.callback(call.arguments[0], []Value{accumulator, l.elements[k]})
+		if err != nil {
+			return Value{}, err
+		}
+	}
+	return accumulator, nil
+}
+
+// join returns the elements as print shows them, with a separator between
+// each pair.
+func (l *LoxList) join(call builtinCall) (Value, error) {
+	separator := call.arguments[0]
+	if separator.kind != StringKind {
+		return Value{}, RuntimeError{call.paren, "Separator must be a string.", nil, Value{}}
+	}
+	parts := make([]string, len(l.elements))
+	for k, element := range l.elements {
+		parts[k] = element.String()
+	}
+	return StringValue(strings.Join(parts, separator.str)), nil
+}
@@ -1,106 +1,188 @@
-package main
+// Package lox implements the Lox language from Crafting Interpreters, with
+// both a tree-walking interpreter and a bytecode virtual machine.
+package lox
 
 import (
 	"bufio"
+	"context"
 	"fmt"
-	"os"
+	"io"
 )
 
-// Global variable gross but better than OOP for a single variable.
-var hadError = false
-var hadRuntimeError = false
+// Options configures an Interpreter created with New.
+type Options struct {
+	// Diagnostics, when set, receives every diagnostic reported while running,
+	// including runtime errors.
+	Diagnostics DiagnosticSink
+	// Stdout receives the output of print statements and the REPL prompt.
+	// Defaults to os.Stdout.
+	Stdout io.Writer
+	// Stderr receives diagnostics rendered with RenderText. Defaults to
+	// os.Stderr, use io.Discard to only get them from Diagnostics.
+	Stderr io.Writer
+	// Stdin is read by RunPrompt. Defaults to os.Stdin.
+	Stdin io.Reader
+	// MaxSteps limits how many statements and expressions a single run may
+	// execute before it is stopped with an *InterruptError. Zero means no limit.
+	// Both backends count the same way, so a script stops at the same point
+	// on either.
+	MaxSteps int
+	// MaxCallDepth is how deeply Lox calls may nest before the run fails with
+	// a "Stack overflow." RuntimeError. Zero uses the default of 4096. Very
+	// large values can exhaust the Go stack, which can't be recovered from.
+	MaxCallDepth int
+	// Backend selects how statements are executed. Defaults to TreeWalker.
+	Backend Backend
+	// Disassemble, when set and Backend is BytecodeVM, receives a listing of
+	// the bytecode compiled for each run before it executes.
+	Disassemble io.Writer
+}
 
-func main() {
-	if len(os.Args) > 2 {
-		fmt.Println("Usage : golox [script]")
-		os.Exit(64)
-	} else if len(os.Args) == 2 {
-		err := runFile(os.Args[1])
-		if err != nil {
-			fmt.Println(err)
-		}
-	} else {
-		err := runPrompt()
-		if err != nil {
-			fmt.Println(err)
-		}
+// Backend is a way of executing resolved Lox code.
+type Backend int
+
+const (
+	// TreeWalker evaluates the syntax tree directly.
+	TreeWalker Backend = iota
+	// BytecodeVM compiles the syntax tree to bytecode and runs it on a
+	// stack machine.
+	BytecodeVM
+)
+
+// New returns an interpreter ready to Run source code. Globals defined by one
+// call to Run stay visible to the next, which is what the REPL relies on.
+func New(opts Options) *Interpreter {
+	interpreter := newInterpreter()
+	interpreter.backend = opts.Backend
+	interpreter.loadPrelude()
+	interpreter.diagnostics = opts.Diagnostics
+	if opts.Stdout != nil {
+		interpreter.stdout = opts.Stdout
+	}
+	if opts.Stderr != nil {
+		interpreter.stderr = opts.Stderr
+	}
+	if opts.Stdin != nil {
+		interpreter.stdin = opts.Stdin
 	}
+	interpreter.maxSteps = opts.MaxSteps
+	if opts.MaxCallDepth > 0 {
+		interpreter.maxCallDepth = opts.MaxCallDepth
+	}
+	interpreter.disassemble = opts.Disassemble
+	return interpreter
+}
+
+// prelude defines the classes every script can use.
+const prelude = `
+class Error {
+  init(message) {
+    this.message = message;
+  }
 }
+`
 
-func runFile(path string) error {
-	source, err := os.ReadFile(path)
+// loadPrelude defines the prelude's classes. It is plain Lox, so it runs on
+// the backend scripts will use and its classes are that backend's own. New
+// calls it before applying the options, so the caller's step budget and
+// output don't apply to it.
+func (i *Interpreter) loadPrelude() {
+	stderr := i.stderr
+	i.stderr = io.Discard
+	err := i.Run(prelude)
+	i.stderr = stderr
 	if err != nil {
-		return err
-	}
-	sourceStr := string(source)
-	interpreter := newInterpreter()
-	run(sourceStr, interpreter)
-	if hadError {
-		os.Exit(65)
+		panic("lox: loading the prelude failed: " + err.Error())
 	}
-	if hadRuntimeError {
-		os.Exit(70)
+	i.errorClass = i.globals.values["Error"]
+	if i.vm != nil {
+		i.vm.errorClass = i.errorClass.object.(*vmClass)
 	}
-	return nil
 }
 
-func runPrompt() error {
-	reader := bufio.NewReader(os.Stdin)
-	interpreter := newInterpreter()
-	for {
-		fmt.Print("> ")
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			return err
-		}
-		run(line, interpreter)
-		hadError = false
-		hadRuntimeError = false
-	}
-	return nil
+// Run scans, parses, resolves and executes source. If scanning, parsing or
+// resolving reports an error nothing is executed and the diagnostics are
+// returned as a DiagnosticList. A failure while executing is returned as a
+// RuntimeError.
+func (i *Interpreter) Run(source string) error {
+	return i.RunContext(context.Background(), source)
 }
 
-func run(source string, interpreter Interpreter) {
-	//	ast := AstPrinter{} // not printing the ast, we are interpreting now!
-	scanner := newScanner(source)
+// RunContext is Run with a context. If ctx is cancelled or its deadline passes
+// while the script is running, execution stops with an *InterruptError. The
+// interpreter can still be used afterwards.
+func (i *Interpreter) RunContext(ctx context.Context, source string) error {
+	var diagnostics DiagnosticList
+	defer func() {
+		i.reportAll(diagnostics)
+	}()
+	scanner := newScanner(source, &diagnostics)
 	tokens := scanner.ScanTokens()
-	parser := Parser{tokens, 0}
+	parser := newParser(tokens, &diagnostics)
 	statements := parser.Parse()
-	if hadError {
-		return
+	if diagnostics.HasErrors() {
+		return diagnostics
 	}
-	resolver := newResolver(interpreter)
-	resolver.resolve(statements)
-	if hadError {
-		return
+	resolver := newResolver(i, &diagnostics)
+	resolver.resolveProgram(statements)
+	if diagnostics.HasErrors() {
+		return diagnostics
 	}
-	interpreter.Interpret(statements)
-
-}
-func emitError(line int, message string) {
-	report(line, "", message)
-}
-func emitRuntimeError(err RuntimeError) {
-	fmt.Fprintln(os.Stderr, err.Message)
-	fmt.Fprintf(os.Stderr, "[line %d]\n", err.Token.Line)
-	hadRuntimeError = true
+	// Warnings belong before anything the program prints.
+	i.reportAll(diagnostics)
+	diagnostics = nil
+	if i.backend == BytecodeVM {
+		return i.runVM(ctx, statements, &diagnostics)
+	}
+	return i.interpret(ctx, statements)
 }
 
-func emitTokenError(t Token, message string) {
-	if t.Type == EOF {
-		report(t.Line, " at end", message)
-	} else {
-		if t.Type == Identifier || t.Type == Number {
-			report(t.Line, " at '"+t.Lexeme+"'", message)
-			return
-		}
-		report(t.Line, " at '"+t.Type.String()+"'", message)
+// reportAll reports diagnostics in source order.
+func (i *Interpreter) reportAll(diagnostics DiagnosticList) {
+	diagnostics.Sort()
+	for _, d := range diagnostics {
+		i.report(d)
 	}
 }
 
-func report(line int, where string, message string) {
-	fmt.Fprintf(os.Stderr, "[line %d] Error%s: %s\n", line, where, message)
-
-	hadError = true
+// runVM compiles statements and runs them on the interpreter's VM, which is
+// created on first use and kept so globals carry over between runs.
+func (i *Interpreter) runVM(ctx context.Context, statements []stmt, diagnostics *DiagnosticList) error {
+	function := compile(statements, diagnostics)
+	if diagnostics.HasErrors() {
+		return *diagnostics
+	}
+	if i.disassemble != nil {
+		disassemble(i.disassemble, function)
+	}
+	if i.vm == nil {
+		i.vm = newVM(i)
+	}
+	err := i.vm.interpret(ctx, function)
+	runtimeError, ok := err.(RuntimeError)
+	if ok {
+		i.report(runtimeError.Diagnostic())
+	}
+	return err
+}
 
+// RunPrompt is the REPL. It prints a prompt, runs a line read from Stdin and
+// repeats until Stdin is exhausted. Errors are reported like any other run
+// and don't stop the loop.
+func (i *Interpreter) RunPrompt() error {
+	reader := bufio.NewReader(i.stdin)
+	for {
+		fmt.Fprint(i.stdout, "> ")
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			i.Run(line)
+		}
+		if err == io.EOF {
+			fmt.Fprintln(i.stdout)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
@@ -0,0 +1,106 @@
+package lox_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jastintime/lox"
+)
+
+var backends = []struct {
+	name    string
+	backend lox.Backend
+}{
+	{"tree", lox.TreeWalker},
+	{"vm", lox.BytecodeVM},
+}
+
+// run runs source on a new interpreter for each backend and returns what it
+// printed and the error Run returned.
+func run(t *testing.T, opts lox.Options, setup func(*lox.Interpreter), source string, check func(t *testing.T, output string, err error)) {
+	t.Helper()
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			var output strings.Builder
+			opts := opts
+			opts.Backend = b.backend
+			opts.Stdout = &output
+			if opts.Stderr == nil {
+				opts.Stderr = &strings.Builder{}
+			}
+			interpreter := lox.New(opts)
+			if setup != nil {
+				setup(interpreter)
+			}
+			err := interpreter.Run(source)
+			check(t, output.String(), err)
+		})
+	}
+}
+
+func TestMaxStepsCountsTheSameOnBothBackends(t *testing.T) {
+	source := `
+for (var i = 0; i < 1000; i = i + 1) {
+  print i;
+}
+`
+	outputs := map[lox.Backend]string{}
+	for _, b := range backends {
+		var output strings.Builder
+		interpreter := lox.New(lox.Options{Backend: b.backend, MaxSteps: 5000, Stdout: &output})
+		err := interpreter.Run(source)
+		if !errors.Is(err, lox.ErrStepBudgetExhausted) {
+			t.Fatalf("%s: Run returned %v, want ErrStepBudgetExhausted", b.name, err)
+		}
+		outputs[b.backend] = output.String()
+	}
+	tree, vm := outputs[lox.TreeWalker], outputs[lox.BytecodeVM]
+	if tree != vm {
+		t.Errorf("tree-walker printed %d lines, VM printed %d", strings.Count(tree, "\n"), strings.Count(vm, "\n"))
+	}
+}
+
+func TestRunContextStopsInfiniteLoop(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			var output strings.Builder
+			interpreter := lox.New(lox.Options{Backend: b.backend, Stdout: &output})
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err := interpreter.RunContext(ctx, `while (true) {}`)
+			var interrupt *lox.InterruptError
+			if !errors.As(err, &interrupt) || !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("RunContext returned %v, want an InterruptError for the deadline", err)
+			}
+			if err := interpreter.Run(`print "still usable";`); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != "still usable\n" {
+				t.Errorf("output = %q", output.String())
+			}
+		})
+	}
+}
+
+func TestStepBudget(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			var output strings.Builder
+			interpreter := lox.New(lox.Options{Backend: b.backend, MaxSteps: 100, Stdout: &output})
+			err := interpreter.Run(`var n = 0; while (true) { n = n + 1; }`)
+			if !errors.Is(err, lox.ErrStepBudgetExhausted) {
+				t.Fatalf("Run returned %v, want ErrStepBudgetExhausted", err)
+			}
+			// The budget is per run, and globals survive the interrupted one.
+			if err := interpreter.Run(`print n > 0;`); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != "true\n" {
+				t.Errorf("output = %q", output.String())
+			}
+		})
+	}
+}
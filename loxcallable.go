@@ -1,6 +1,6 @@
-package main
+package lox
 
-type LoxCallable interface {
+type loxCallable interface {
 	Arity() int
-	Call(interpreter Interpreter, arguments []any) any
+	Call(interpreter *Interpreter, arguments []Value) Value
 }
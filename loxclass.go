@@ -1,26 +1,34 @@
-package main
+package lox
 
-type LoxClass struct {
-	Name       string
-	Superclass *LoxClass
-	Methods    map[string]LoxFunction
+import (
+	"iter"
+	"maps"
+)
+
+type loxClass struct {
+	Name          string
+	Superclass    *loxClass
+	Methods       map[string]*loxFunction
+	StaticMethods map[string]*loxFunction
+	// fields are the class's static fields, created by assigning to them.
+	fields map[string]Value
 }
 
-func (l LoxClass) String() string {
+func (l *loxClass) String() string {
 	return l.Name
 }
 
-func (l LoxClass) Call(interpreter Interpreter, arguments []any) any {
+func (l *loxClass) Call(interpreter *Interpreter, arguments []Value) Value {
 	instance := newLoxInstance(l)
 	initializer, exist := l.FindMethod("init")
 	if exist {
 		initializer.Bind(instance).Call(interpreter, arguments)
 	}
 
-	return instance
+	return objectValue(InstanceKind, instance)
 }
 
-func (l LoxClass) Arity() int {
+func (l *loxClass) Arity() int {
 	initializer, exist := l.FindMethod("init")
 	if !exist {
 		return 0
@@ -28,7 +36,29 @@ func (l LoxClass) Arity() int {
 	return initializer.Arity()
 }
 
-func (l LoxClass) FindMethod(name string) (LoxFunction, bool) {
+// methodNames yields the name of every method FindMethod can find.
+func (l *loxClass) methodNames() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for class := l; class != nil; class = class.Superclass {
+			for name := range maps.Keys(class.Methods) {
+				if !yield(name) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *loxClass) isSubclassOf(other *loxClass) bool {
+	for class := l; class != nil; class = class.Superclass {
+		if class == other {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *loxClass) FindMethod(name string) (*loxFunction, bool) {
 	value, ok := l.Methods[name]
 	if ok {
 		return value, true
@@ -36,22 +66,62 @@ func (l LoxClass) FindMethod(name string) (LoxFunction, bool) {
 	if l.Superclass != nil {
 		return l.Superclass.FindMethod(name)
 	}
-	return LoxFunction{}, false
+	return nil, false
 }
 
-func (l LoxClass) Equals(other LoxClass) bool {
-	if (l.Name == other.Name && l.Superclass == other.Superclass) == false {
-		return false
+// Get looks up a static field or method, bound to l, on the class or the
+// classes it inherits from. At each class its fields come before its methods.
+func (l *loxClass) Get(name Token) Value {
+	for class := l; class != nil; class = class.Superclass {
+		value, ok := class.fields[name.Lexeme]
+		if ok {
+			return value
+		}
+		method, ok := class.StaticMethods[name.Lexeme]
+		if ok {
+			return objectValue(FunctionKind, method.bind(objectValue(ClassKind, l)))
+		}
 	}
-	for k, v := range l.Methods {
-		ov, ok := other.Methods[k]
-		if !ok {
-			return false
+	panic(RuntimeError{name, undefined("property", name.Lexeme, l.staticNames()), nil, Value{}})
+}
+
+func (l *loxClass) Set(name Token, value Value) {
+	l.fields[name.Lexeme] = value
+}
+
+// findStaticMethod is FindMethod for static methods.
+func (l *loxClass) findStaticMethod(name string) (*loxFunction, bool) {
+	for class := l; class != nil; class = class.Superclass {
+		method, ok := class.StaticMethods[name]
+		if ok {
+			return method, true
+		}
+	}
+	return nil, false
+}
+
+// staticMethodNames yields the name of every method findStaticMethod can find.
+func (l *loxClass) staticMethodNames() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for class := l; class != nil; class = class.Superclass {
+			for name := range maps.Keys(class.StaticMethods) {
+				if !yield(name) {
+					return
+				}
+			}
 		}
+	}
+}
 
-		if !v.Equals(ov) {
-			return false
+// staticNames yields the name of every static field and method Get can find.
+func (l *loxClass) staticNames() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for class := l; class != nil; class = class.Superclass {
+			for name := range concat(maps.Keys(class.fields), maps.Keys(class.StaticMethods)) {
+				if !yield(name) {
+					return
+				}
+			}
 		}
 	}
-	return true
 }
@@ -1,68 +1,59 @@
-package main
+package lox
 
-type LoxFunction struct {
-	Declaration   FunctionStmt
-	Closure       Environment
+type loxFunction struct {
+	Declaration   *functionStmt
+	Closure       *environment
 	isInitializer bool
+	// class is the class a method was declared in, nil for functions.
+	class *loxClass
 }
 
-func (l *LoxFunction) Bind(instance LoxInstance) LoxFunction {
-	environment := newEnvironment(&l.Closure)
-	environment.Define("this", instance)
-	return newLoxFunction(l.Declaration, environment, l.isInitializer)
+func (l *loxFunction) Bind(instance *loxInstance) *loxFunction {
+	return l.bind(objectValue(InstanceKind, instance))
 }
 
-func newLoxFunction(declaration FunctionStmt, closure Environment, isInitializer bool) LoxFunction {
-	return LoxFunction{declaration, closure, isInitializer}
+// bind returns the method with this defined as the given instance, or the
+// class for a static method.
+func (l *loxFunction) bind(this Value) *loxFunction {
+	environment := newEnvironment(l.Closure, 1)
+	environment.DefineAt(0, this)
+	return newLoxFunction(l.Declaration, environment, l.isInitializer, l.class)
+}
+
+func newLoxFunction(declaration *functionStmt, closure *environment, isInitializer bool, class *loxClass) *loxFunction {
+	return &loxFunction{declaration, closure, isInitializer, class}
 }
 
 // BEAUTY
-func (l LoxFunction) Call(interpreter Interpreter, arguments []any) (result any) {
+func (l *loxFunction) Call(interpreter *Interpreter, arguments []Value) (result Value) {
 	defer func() {
-		result = recover()
+		recovered := recover()
+		v, ok := recovered.(returnValue)
+		if recovered != nil && !ok {
+			// runtime errors and interrupts keep unwinding, even out of init()
+			panic(recovered)
+		}
 		if l.isInitializer {
-			result = l.Closure.GetAt(0, "this")
+			result = l.Closure.GetAt(0, 0)
 			return
 		}
-		v, ok := result.(ReturnValue)
 		if ok {
-			result = v.Unbox()
-			return
-		}
-		if result != nil {
-			panic(result)
+			result = v.Value
 		}
-
 	}()
 
-	environment := newEnvironment(&l.Closure)
+	environment := newEnvironment(l.Closure, l.Declaration.size)
 	for i := 0; i < len(l.Declaration.Params); i++ {
-		environment.Define(l.Declaration.Params[i].Lexeme, arguments[i])
+		environment.DefineAt(i, arguments[i])
 	}
 	interpreter.executeBlock(l.Declaration.Body, environment)
-	if l.isInitializer {
-		l.Closure.GetAt(0, "this")
-	}
-	return nil
+	return Value{}
 }
 
-func (l LoxFunction) Arity() int {
+func (l *loxFunction) Arity() int {
 	return len(l.Declaration.Params)
 }
 
-func (l LoxFunction) String() string {
+func (l *loxFunction) String() string {
 	return "<fn " + l.Declaration.Name.Lexeme + ">"
 }
-
-func (l LoxFunction) Equals(other LoxFunction) bool {
-	if l.isInitializer != other.isInitializer {
-		return false
-	}
-	if !l.Declaration.Equals(other.Declaration) {
-		return false
-	}
-	if !l.Closure.Equals(other.Closure) {
-		return false
-	}
-	return true
-}
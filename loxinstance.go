@@ -1,37 +1,33 @@
-package main
+package lox
 
 import "maps"
 
-type LoxInstance struct {
-	Class  LoxClass
-	fields map[string]any
+type loxInstance struct {
+	Class  *loxClass
+	fields map[string]Value
 }
 
-func newLoxInstance(class LoxClass) LoxInstance {
-	return LoxInstance{class, make(map[string]any)}
+func newLoxInstance(class *loxClass) *loxInstance {
+	return &loxInstance{class, make(map[string]Value)}
 }
 
-func (l LoxInstance) String() string {
+func (l *loxInstance) String() string {
 	return l.Class.Name + " instance"
 }
 
-func (l LoxInstance) Get(name Token) any {
+func (l *loxInstance) Get(name Token) Value {
 	value, ok := l.fields[name.Lexeme]
 	if ok {
 		return value
 	}
 	method, exist := l.Class.FindMethod(name.Lexeme)
 	if exist {
-		return method.Bind(l)
+		return objectValue(FunctionKind, method.Bind(l))
 	}
-	panic(RuntimeError{name, "Undefined property '" + name.Lexeme + "'."})
-	return nil
+	candidates := concat(maps.Keys(l.fields), l.Class.methodNames())
+	panic(RuntimeError{name, undefined("property", name.Lexeme, candidates), nil, Value{}})
 }
 
-func (l LoxInstance) Set(name Token, value any) {
+func (l *loxInstance) Set(name Token, value Value) {
 	l.fields[name.Lexeme] = value
 }
-
-func (l LoxInstance) Equals(other LoxInstance) bool {
-	return l.Class.Equals(other.Class) && maps.EqualFunc(l.fields, other.fields, isEqual)
-}
@@ -0,0 +1,200 @@
+package lox
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"slices"
+)
+
+// LoxMap is a map created by a map literal. Keys may be strings, numbers,
+// booleans or nil, and entries are kept in the order their keys were first
+// added so printing and iterating are deterministic. Both backends use it.
+type LoxMap struct {
+	entries []mapEntry
+	// index is the position of each key's entry.
+	index map[Value]int
+}
+
+type mapEntry struct {
+	key   Value
+	value Value
+}
+
+func newLoxMap() *LoxMap {
+	return &LoxMap{nil, make(map[Value]int)}
+}
+
+// NewMap returns an empty map, for a native to fill in and return.
+func NewMap() *LoxMap {
+	return newLoxMap()
+}
+
+// Len returns the number of entries in the map.
+func (m *LoxMap) Len() int {
+	return len(m.entries)
+}
+
+// Get returns the value for key, ok is false if the map doesn't have it.
+func (m *LoxMap) Get(key Value) (value Value, ok bool) {
+	position, ok := m.index[key]
+	if !ok {
+		return Value{}, false
+	}
+	return m.entries[position].value, true
+}
+
+// Set adds or replaces the entry for key. It fails for a key a script
+// couldn't use either.
+func (m *LoxMap) Set(key Value, value Value) error {
+	if problem := keyProblem(key); problem != "" {
+		return errors.New(problem)
+	}
+	return m.set(key, value, Token{})
+}
+
+// Keys returns the keys of the map in the order they were added.
+func (m *LoxMap) Keys() []Value {
+	keys := make([]Value, len(m.entries))
+	for k, entry := range m.entries {
+		keys[k] = entry.key
+	}
+	return keys
+}
+
+func (m *LoxMap) String() string {
+	return elementString(objectValue(MapKind, m))
+}
+
+// checkKey reports an error at token for a value that can't be a key.
+func checkKey(key Value, token Token) error {
+	if problem := keyProblem(key); problem != "" {
+		return RuntimeError{token, problem, nil, Value{}}
+	}
+	return nil
+}
+
+// keyProblem says why a value can't be a key, or is "" if it can. NaN is left
+// out because it isn't equal to itself, so it could never be found again.
+func keyProblem(key Value) string {
+	switch key.kind {
+	case NilKind, BoolKind, StringKind:
+		return ""
+	case NumberKind:
+		if math.IsNaN(key.number) {
+			return "Map key can't be NaN."
+		}
+		return ""
+	}
+	return "Map keys must be strings, numbers, booleans or nil."
+}
+
+func (m *LoxMap) get(key Value, bracket Token) (Value, error) {
+	err := checkKey(key, bracket)
+	if err != nil {
+		return Value{}, err
+	}
+	position, ok := m.index[key]
+	if !ok {
+		return Value{}, RuntimeError{bracket, "Key " + elementString(key) + " is not in the map.", nil, Value{}}
+	}
+	return m.entries[position].value, nil
+}
+
+func (m *LoxMap) set(key Value, value Value, bracket Token) error {
+	err := checkKey(key, bracket)
+	if err != nil {
+		return err
+	}
+	position, ok := m.index[key]
+	if ok {
+		m.entries[position].value = value
+		return nil
+	}
+	m.index[key] = len(m.entries)
+	m.entries = append(m.entries, mapEntry{key, value})
+	return nil
+}
+
+// mapMethod is one of the methods every map has.
+type mapMethod struct {
+	arity int
+	fn    func(m *LoxMap, call builtinCall) (Value, error)
+}
+
+var mapMethods = map[string]mapMethod{
+	"entries": {0, (*LoxMap).entriesList},
+	"has":     {1, (*LoxMap).has},
+	"keys":    {0, (*LoxMap).keys},
+	"len":     {0, (*LoxMap).len},
+	"remove":  {1, (*LoxMap).remove},
+	"values":  {0, (*LoxMap).values},
+}
+
+// method returns the map method called name bound to m.
+func (m *LoxMap) method(name string, token Token) (*builtinMethod, error) {
+	method, ok := mapMethods[name]
+	if !ok {
+		return nil, RuntimeError{token, undefined("property", name, maps.Keys(mapMethods)), nil, Value{}}
+	}
+	fn := func(call builtinCall) (Value, error) {
+		return method.fn(m, call)
+	}
+	return &builtinMethod{"map", name, method.arity, method.arity, fn}, nil
+}
+
+func (m *LoxMap) has(call builtinCall) (Value, error) {
+	key := call.arguments[0]
+	err := checkKey(key, call.paren)
+	if err != nil {
+		return Value{}, err
+	}
+	_, ok := m.index[key]
+	return BoolValue(ok), nil
+}
+
+// remove deletes a key and returns the value it had, or nil if it wasn't in
+// the map.
+func (m *LoxMap) remove(call builtinCall) (Value, error) {
+	key := call.arguments[0]
+	err := checkKey(key, call.paren)
+	if err != nil {
+		return Value{}, err
+	}
+	position, ok := m.index[key]
+	if !ok {
+		return Value{}, nil
+	}
+	value := m.entries[position].value
+	m.entries = slices.Delete(m.entries, position, position+1)
+	delete(m.index, key)
+	for k := position; k < len(m.entries); k++ {
+		m.index[m.entries[k].key] = k
+	}
+	return value, nil
+}
+
+func (m *LoxMap) len(call builtinCall) (Value, error) {
+	return NumberValue(float64(len(m.entries))), nil
+}
+
+func (m *LoxMap) keys(call builtinCall) (Value, error) {
+	return objectValue(ListKind, newLoxList(m.Keys())), nil
+}
+
+func (m *LoxMap) values(call builtinCall) (Value, error) {
+	values := make([]Value, len(m.entries))
+	for k, entry := range m.entries {
+		values[k] = entry.value
+	}
+	return objectValue(ListKind, newLoxList(values)), nil
+}
+
+// entriesList returns a list of [key, value] lists, one for each entry.
+func (m *LoxMap) entriesList(call builtinCall) (Value, error) {
+	entries := make([]Value, len(m.entries))
+	for k, entry := range m.entries {
+		entries[k] = objectValue(ListKind, newLoxList([]Value{entry.key, entry.value}))
+	}
+	return objectValue(ListKind, newLoxList(entries)), nil
+}
@@ -0,0 +1,199 @@
+package lox
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+)
+
+var (
+	errorType = reflect.TypeFor[error]()
+	valueType = reflect.TypeFor[Value]()
+	// loxTypes are the types other than Go's basic ones that natives can take
+	// and return. Both backends share them, unlike instances, classes and
+	// functions, which a native can only see as a Value.
+	loxTypes = []reflect.Type{
+		valueType,
+		reflect.TypeFor[*LoxList](),
+		reflect.TypeFor[*LoxMap](),
+	}
+)
+
+// nativeFunction is a Go function exposed to Lox through DefineFunc. Arguments
+// and results are converted between Lox values and the Go signature with
+// reflection.
+type nativeFunction struct {
+	name string
+	fn   reflect.Value
+}
+
+// DefineFunc makes the Go function fn callable from Lox as a global called
+// name. Parameters and the result may be any numeric kind, string, bool,
+// Value, any, *LoxList or *LoxMap, and DefineFunc returns an error for any
+// other type. An any parameter receives nil, a float64, string, bool, *LoxList
+// or *LoxMap, or a Value for anything else. fn may return nothing, one
+// value, an error, or a value and an error. A non-nil error is thrown at the
+// call site, as an Error carrying its message unless it came from ThrowValue.
+func (i *Interpreter) DefineFunc(name string, fn any) error {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func {
+		return fmt.Errorf("lox: DefineFunc %q: %T is not a function", name, fn)
+	}
+	t := value.Type()
+	if t.IsVariadic() {
+		return fmt.Errorf("lox: DefineFunc %q: variadic functions are not supported", name)
+	}
+	for p := range t.NumIn() {
+		if !convertible(t.In(p)) {
+			return fmt.Errorf("lox: DefineFunc %q: unsupported parameter type %v", name, t.In(p))
+		}
+	}
+	switch {
+	case t.NumOut() > 2:
+		return fmt.Errorf("lox: DefineFunc %q: too many results", name)
+	case t.NumOut() == 2 && t.Out(1) != errorType:
+		return fmt.Errorf("lox: DefineFunc %q: second result must be an error", name)
+	case t.NumOut() > 0 && t.Out(0) != errorType && !convertible(t.Out(0)):
+		return fmt.Errorf("lox: DefineFunc %q: unsupported result type %v", name, t.Out(0))
+	}
+	i.globals.Define(name, objectValue(NativeKind, &nativeFunction{name, value}))
+	return nil
+}
+
+func (n *nativeFunction) Arity() int {
+	return n.fn.Type().NumIn()
+}
+
+// Call is only used when nothing knows the call site, visitCallExpr uses call
+// so errors point at the closing paren.
+func (n *nativeFunction) Call(interpreter *Interpreter, arguments []Value) Value {
+	result, err := n.call(arguments)
+	if err != nil {
+		panic(RuntimeError{Token{Lexeme: n.name}, err.Error(), nil, Value{}})
+	}
+	return result
+}
+
+func (n *nativeFunction) call(arguments []Value) (Value, error) {
+	t := n.fn.Type()
+	in := make([]reflect.Value, len(arguments))
+	for p, argument := range arguments {
+		value, err := fromLox(argument, t.In(p))
+		if err != nil {
+			return Value{}, fmt.Errorf("Argument %d to '%s' %v", p+1, n.name, err)
+		}
+		in[p] = value
+	}
+	out := n.fn.Call(in)
+	if len(out) > 0 && t.Out(len(out)-1) == errorType {
+		last := out[len(out)-1]
+		if !last.IsNil() {
+			return Value{}, last.Interface().(error)
+		}
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		return Value{}, nil
+	}
+	return toLox(out[0])
+}
+
+func (n *nativeFunction) String() string {
+	return "<native fn>"
+}
+
+// convertible reports whether natives can take and return values of type t.
+func convertible(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.String, reflect.Bool:
+		return true
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	}
+	return slices.Contains(loxTypes, t)
+}
+
+// fromLox converts the Lox value to the Go type t. The error is phrased to
+// follow "Argument N to 'name'".
+func fromLox(value Value, t reflect.Type) (reflect.Value, error) {
+	if t == valueType {
+		return reflect.ValueOf(value), nil
+	}
+	if value.IsNil() {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, errors.New("can't be nil.")
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if value.kind != NumberKind {
+			return reflect.Value{}, errors.New("must be a number.")
+		}
+		return reflect.ValueOf(value.number).Convert(t), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number := value.number
+		if value.kind != NumberKind || number != math.Trunc(number) {
+			return reflect.Value{}, errors.New("must be an integer.")
+		}
+		converted := reflect.ValueOf(number).Convert(t)
+		if converted.Convert(reflect.TypeFor[float64]()).Float() != number {
+			return reflect.Value{}, errors.New("is out of range.")
+		}
+		return converted, nil
+	case reflect.String:
+		if value.kind != StringKind {
+			return reflect.Value{}, errors.New("must be a string.")
+		}
+		return reflect.ValueOf(value.str).Convert(t), nil
+	case reflect.Bool:
+		if value.kind != BoolKind {
+			return reflect.Value{}, errors.New("must be a boolean.")
+		}
+		return reflect.ValueOf(value.boolean).Convert(t), nil
+	}
+	v := reflect.ValueOf(value.goValue())
+	if !v.Type().AssignableTo(t) {
+		return reflect.Value{}, fmt.Errorf("must be a %v.", t)
+	}
+	return v, nil
+}
+
+// toLox converts a Go result to the Lox value it stands for.
+func toLox(v reflect.Value) (Value, error) {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return NumberValue(v.Float()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NumberValue(float64(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return NumberValue(float64(v.Uint())), nil
+	case reflect.String:
+		return StringValue(v.String()), nil
+	case reflect.Bool:
+		return BoolValue(v.Bool()), nil
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return Value{}, nil
+		}
+		if v.Kind() == reflect.Interface {
+			return toLox(v.Elem())
+		}
+	}
+	switch value := v.Interface().(type) {
+	case Value:
+		return value, nil
+	case *LoxList:
+		return objectValue(ListKind, value), nil
+	case *LoxMap:
+		return objectValue(MapKind, value), nil
+	}
+	return Value{}, fmt.Errorf("Can't convert Go value of type %v to a Lox value.", v.Type())
+}
@@ -0,0 +1,263 @@
+package lox_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jastintime/lox"
+)
+
+func TestNativeArguments(t *testing.T) {
+	setup := func(interpreter *lox.Interpreter) {
+		natives := map[string]any{
+			"describe": func(v any) string {
+				if value, ok := v.(lox.Value); ok {
+					return value.Kind().String() + " " + value.String()
+				}
+				return fmt.Sprintf("%T %v", v, v)
+			},
+			"identity": func(v lox.Value) lox.Value { return v },
+			"echo":     func(v any) any { return v },
+			"same":     func(list *lox.LoxList) *lox.LoxList { return list },
+		}
+		for name, fn := range natives {
+			if err := interpreter.DefineFunc(name, fn); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	source := `
+class Point { init(x) { this.x = x; } }
+fun f() {}
+print describe(1);
+print describe("a");
+print describe(nil);
+print describe(Point);
+print describe(Point(1));
+print describe(f);
+print identity(Point(2)).x;
+print echo(Point(3)).x;
+print echo(f) == f;
+var xs = [1, 2];
+print same(xs) == xs;
+`
+	want := `float64 1
+string a
+<nil> <nil>
+class Point
+instance Point instance
+function <fn f>
+2
+3
+true
+true
+`
+	run(t, lox.Options{}, setup, source, func(t *testing.T, output string, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != want {
+			t.Errorf("output:\n%s\nwant:\n%s", output, want)
+		}
+	})
+}
+
+func TestDefineFuncRejectsUnconvertibleTypes(t *testing.T) {
+	interpreter := lox.New(lox.Options{})
+	for name, fn := range map[string]any{
+		"reader":  func(fmt.Stringer) {},
+		"pointer": func(*int) {},
+		"slice":   func() []int { return nil },
+		"results": func() (int, int) { return 0, 0 },
+		"value":   42,
+	} {
+		if err := interpreter.DefineFunc(name, fn); err == nil {
+			t.Errorf("DefineFunc(%q) succeeded", name)
+		}
+	}
+}
+
+func TestNativeInstances(t *testing.T) {
+	setup := func(interpreter *lox.Interpreter) {
+		natives := map[string]any{
+			"norm": func(p lox.Value) (float64, error) {
+				x, okX := p.Field("x")
+				y, okY := p.Field("y")
+				if !okX || !okY {
+					return 0, fmt.Errorf("%s has no x and y.", p.ClassName())
+				}
+				return x.AsNumber()*x.AsNumber() + y.AsNumber()*y.AsNumber(), nil
+			},
+			"tag": func(p lox.Value, tag string) bool {
+				return p.SetField("tag", lox.StringValue(tag+" "+p.ClassName()))
+			},
+		}
+		for name, fn := range natives {
+			if err := interpreter.DefineFunc(name, fn); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	source := `
+class Point {
+  init(x, y) { this.x = x; this.y = y; }
+  m() {}
+}
+var p = Point(3, 4);
+print norm(p);
+print tag(p, "a");
+print p.tag;
+print tag(Point, "b");
+print tag(1, "c");
+try { norm(Point); } catch (e) { print e.message; }
+`
+	want := `25
+true
+a Point
+false
+false
+Point has no x and y.
+`
+	run(t, lox.Options{}, setup, source, func(t *testing.T, output string, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != want {
+			t.Errorf("output:\n%s\nwant:\n%s", output, want)
+		}
+	})
+}
+
+func TestNativeLists(t *testing.T) {
+	setup := func(interpreter *lox.Interpreter) {
+		natives := map[string]any{
+			"sum": func(list *lox.LoxList) float64 {
+				total := 0.0
+				for k := range list.Len() {
+					total += list.At(k).AsNumber()
+				}
+				return total
+			},
+			"append": func(list *lox.LoxList, value lox.Value) {
+				list.Append(value)
+			},
+			"range": func(n int) *lox.LoxList {
+				list := lox.NewList()
+				for k := range n {
+					list.Append(lox.NumberValue(float64(k)))
+				}
+				return list
+			},
+		}
+		for name, fn := range natives {
+			if err := interpreter.DefineFunc(name, fn); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	source := `
+var xs = [1, 2, 3];
+print sum(xs);
+append(xs, "a");
+print xs;
+print range(4);
+print range(3).map((x) => x * 2);
+`
+	want := `6
+[1, 2, 3, "a"]
+[0, 1, 2, 3]
+[0, 2, 4]
+`
+	run(t, lox.Options{}, setup, source, func(t *testing.T, output string, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != want {
+			t.Errorf("output:\n%s\nwant:\n%s", output, want)
+		}
+	})
+}
+
+func TestNativeMaps(t *testing.T) {
+	setup := func(interpreter *lox.Interpreter) {
+		natives := map[string]any{
+			"lookup": func(m *lox.LoxMap, key string) lox.Value {
+				value, ok := m.Get(lox.StringValue(key))
+				if !ok {
+					return lox.StringValue("missing")
+				}
+				return value
+			},
+			"invert": func(m *lox.LoxMap) (*lox.LoxMap, error) {
+				inverted := lox.NewMap()
+				for _, key := range m.Keys() {
+					value, _ := m.Get(key)
+					if err := inverted.Set(value, key); err != nil {
+						return nil, err
+					}
+				}
+				return inverted, nil
+			},
+			"size": func(m *lox.LoxMap) int { return m.Len() },
+		}
+		for name, fn := range natives {
+			if err := interpreter.DefineFunc(name, fn); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	source := `
+var m = {"a": 1, b: 2};
+print lookup(m, "b");
+print lookup(m, "c");
+print invert(m);
+print size(m);
+try { invert({"a": [1]}); } catch (e) { print e.message; }
+`
+	want := `2
+missing
+{1: "a", 2: "b"}
+2
+Map keys must be strings, numbers, booleans or nil.
+`
+	run(t, lox.Options{}, setup, source, func(t *testing.T, output string, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != want {
+			t.Errorf("output:\n%s\nwant:\n%s", output, want)
+		}
+	})
+}
+
+func TestNativeErrorIsRuntimeError(t *testing.T) {
+	setup := func(interpreter *lox.Interpreter) {
+		err := interpreter.DefineFunc("fail", func(message string) error {
+			return errors.New(message)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := `
+print "before";
+fail("it broke");
+print "after";
+`
+	run(t, lox.Options{}, setup, source, func(t *testing.T, output string, err error) {
+		var runtimeError lox.RuntimeError
+		if !errors.As(err, &runtimeError) {
+			t.Fatalf("Run returned %v, want a RuntimeError", err)
+		}
+		if runtimeError.Message != "it broke" {
+			t.Errorf("Message = %q, want %q", runtimeError.Message, "it broke")
+		}
+		if runtimeError.Token.Lexeme != ")" || runtimeError.Token.Line != 3 {
+			t.Errorf("error at %q on line %d, want the closing paren on line 3", runtimeError.Token.Lexeme, runtimeError.Token.Line)
+		}
+		if output != "before\n" {
+			t.Errorf("output = %q", output)
+		}
+	})
+}
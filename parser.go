@@ -1,21 +1,32 @@
-package main
-
-type Parser struct {
-	Tokens  []*Token
-	current int
-}
-
-type ParseError struct {
+package lox
+
+type parser struct {
+	Tokens      []*Token
+	current     int
+	diagnostics DiagnosticSink
+	// depth is how many blocks and class bodies enclose the current token, so
+	// synchronize can stop at the '}' closing one instead of skipping it.
+	depth int
+	// parens is how many '(' are open in the current block, so synchronize
+	// doesn't take a ';' in a for loop's clauses for the end of a statement.
+	parens int
+	// unclosed is set once running out of source inside braces is reported,
+	// every enclosing block would report it again otherwise.
+	unclosed bool
+}
+
+type parseError struct {
 	Token  Token
 	Messge string
+	Notes  []Note
 }
 
-func newParser(tokens []*Token) *Parser {
-	return &Parser{tokens, 0}
+func newParser(tokens []*Token, diagnostics DiagnosticSink) *parser {
+	return &parser{tokens, 0, diagnostics, 0, 0, false}
 }
 
-func (p *Parser) Parse() []Stmt {
-	var statements []Stmt
+func (p *parser) Parse() []stmt {
+	var statements []stmt
 	for !p.isAtEnd() {
 		statements = append(statements, p.declaration())
 	}
@@ -24,31 +35,31 @@ func (p *Parser) Parse() []Stmt {
 
 }
 
-func (p *Parser) expression() Expr {
-	return p.assignment()
+func (p *parser) expression() expr {
+	return p.comma()
 }
 
-func (p *Parser) declaration() Stmt {
-	//NOTE: this is golang try catching :)
-	defer func() {
-		recovered := recover()
-		parseError, ok := recovered.(ParseError)
-		if ok {
-			emitTokenError(parseError.Token, parseError.Messge)
-			p.synchronize()
-			return
-		}
-		//STILL WANT TO PANIC OTHERWISE
-		if recovered != nil {
-			panic(recovered)
-		}
-	}()
+// comma parses expressions separated by the comma operator, the lowest
+// precedence there is. Where a comma separates things instead, like the
+// arguments of a call or the elements of a list, each one is parsed with
+// assignment so the comma is left alone.
+func (p *parser) comma() expr {
+	expr := p.assignment()
+	for p.match(Comma) {
+		expr = &commaExpr{expr, p.assignment()}
+	}
+	return expr
+}
+
+func (p *parser) declaration() stmt {
+	defer p.recoverError()
 
 	if p.match(Class) {
 		return p.classDeclaration()
 	}
 
-	if p.match(Fun) {
+	if p.check(Fun) && p.peekNext().Type != LeftParen {
+		p.advance()
 		return p.function("function")
 	}
 
@@ -59,28 +70,53 @@ func (p *Parser) declaration() Stmt {
 	return p.statement()
 }
 
-func (p *Parser) classDeclaration() (result Stmt) {
+func (p *parser) classDeclaration() (result stmt) {
 	name := p.consume(Identifier, "Expect class name.")
-	var superclass *VariableExpr = nil
+	var superclass *variableExpr = nil
 	if p.match(Less) {
 		p.consume(Identifier, "Expect superclass name.")
-		superclass = &VariableExpr{p.previous()}
+		superclass = &variableExpr{p.previous(), nil}
 	}
 
-	p.consume(LeftBrace, "Expect '{' before class body.")
+	open := p.consume(LeftBrace, "Expect '{' before class body.")
 
-	var methods []FunctionStmt
+	var methods, statics []*functionStmt
+	p.depth++
 	for !p.check(RightBrace) && !p.isAtEnd() {
-		methods = append(methods, p.function("method"))
+		static := p.match(Class)
+		method := p.method()
+		if method != nil && static {
+			statics = append(statics, method)
+		} else if method != nil {
+			methods = append(methods, method)
+		}
 	}
-	p.consume(RightBrace, "Expect '}' after class body.")
-	return ClassStmt{name, superclass, methods}
+	p.depth--
+	p.closeBrace(open, "Expect '}' after class body.")
+	return &classStmt{name, superclass, methods, statics, nil}
 
 }
 
-func (p *Parser) statement() Stmt {
+// method parses one method of a class body, after the 'class' of a static
+// one. An error in it is recovered from like a declaration's, so the rest of
+// the class is still checked.
+func (p *parser) method() *functionStmt {
+	defer p.recoverError()
+	return p.function("method")
+}
+
+func (p *parser) statement() stmt {
+	if p.check(Identifier) && p.peekNext().Type == Colon {
+		return p.labeledStatement()
+	}
+	if p.match(Break) {
+		return p.breakStatement()
+	}
+	if p.match(Continue) {
+		return p.continueStatement()
+	}
 	if p.match(For) {
-		return p.forStatement()
+		return p.forStatement(nil)
 	}
 	if p.match(If) {
 		return p.ifStatement()
@@ -91,18 +127,79 @@ func (p *Parser) statement() Stmt {
 	if p.match(Return) {
 		return p.returnStatement()
 	}
+	if p.match(Throw) {
+		return p.throwStatement()
+	}
+	if p.match(Try) {
+		return p.tryStatement()
+	}
 	if p.match(While) {
-		return p.WhileStatement()
+		return p.WhileStatement(nil)
 	}
-	if p.match(LeftBrace) {
-		return BlockStmt{p.block()}
+	if p.check(LeftBrace) && !p.startsMap() {
+		p.advance()
+		return &blockStmt{p.block(), 0}
 	}
 	return p.expressionStatement()
 }
 
-func (p *Parser) forStatement() Stmt {
+// startsMap reports whether the '{' starting a statement opens a map literal
+// rather than a block, which it does when a key and a ':' follow. An
+// identifier and a ':' followed by a loop is a labeled loop inside a block.
+func (p *parser) startsMap() bool {
+	if p.peekAt(2).Type != Colon {
+		return false
+	}
+	switch p.peekAt(1).Type {
+	case String, Number, True, False, Nil:
+		return true
+	case Identifier:
+		next := p.peekAt(3).Type
+		return next != While && next != For
+	}
+	return false
+}
+
+// labeledStatement parses a loop with a label break and continue can name.
+func (p *parser) labeledStatement() stmt {
+	label := p.advance()
+	p.advance()
+	if p.match(While) {
+		return p.WhileStatement(&label)
+	}
+	if p.match(For) {
+		return p.forStatement(&label)
+	}
+	panic(parseError{p.peek(), "Expect a loop after label '" + label.Lexeme + "'.", nil})
+}
+
+func (p *parser) breakStatement() stmt {
+	keyword := p.previous()
+	label := p.jumpLabel()
+	p.semicolon("Expect ';' after 'break'.")
+	return &breakStmt{keyword, label}
+}
+
+func (p *parser) continueStatement() stmt {
+	keyword := p.previous()
+	label := p.jumpLabel()
+	p.semicolon("Expect ';' after 'continue'.")
+	return &continueStmt{keyword, label}
+}
+
+// jumpLabel parses the optional label after break or continue.
+func (p *parser) jumpLabel() *Token {
+	if !p.match(Identifier) {
+		return nil
+	}
+	label := p.previous()
+	return &label
+}
+
+func (p *parser) forStatement(label *Token) stmt {
+	keyword := p.previous()
 	p.consume(LeftParen, "Expect '(' after 'for'.")
-	var initializer Stmt
+	var initializer stmt
 	if p.match(Semicolon) {
 		initializer = nil
 	} else if p.match(Var) {
@@ -110,226 +207,511 @@ func (p *Parser) forStatement() Stmt {
 	} else {
 		initializer = p.expressionStatement()
 	}
-	var condition Expr = nil
+	var condition expr = nil
 	if !p.check(Semicolon) {
 		condition = p.expression()
+		p.checkCondition(condition)
 	}
 	p.consume(Semicolon, "Expect ';' after loop condition.")
 
-	var increment Expr = nil
+	var increment expr = nil
 	if !p.check(RightParen) {
 		increment = p.expression()
 	}
 	p.consume(RightParen, "Expect ')' after for clauses.")
 	body := p.statement()
-	if increment != nil {
-		body = BlockStmt{[]Stmt{body, ExprStmt{increment}}}
-	}
 	if condition == nil {
-		condition = LiteralExpr{true}
+		condition = &literalExpr{BoolValue(true)}
 	}
-	body = WhileStmt{condition, body}
+	body = &whileStmt{keyword, condition, body, increment, label}
 	if initializer != nil {
-		body = BlockStmt{[]Stmt{initializer, body}}
+		body = &blockStmt{[]stmt{initializer, body}, 0}
 	}
 	return body
 }
 
-func (p *Parser) ifStatement() Stmt {
-	p.consume(LeftParen, "Expect '(' after 'if'.")
-	condition := p.expression()
-	p.consume(RightParen, "Expect ')' after if condition.")
+func (p *parser) ifStatement() stmt {
+	keyword := p.previous()
+	condition := p.condition("Expect ')' after if condition.")
 
 	thenBranch := p.statement()
-	var elseBranch Stmt = nil
+	var elseBranch stmt = nil
 	if p.match(Else) {
 		elseBranch = p.statement()
 	}
-	return IfStmt{condition, thenBranch, elseBranch}
+	return &ifStmt{keyword, condition, thenBranch, elseBranch}
 }
 
-func (p *Parser) printStatement() Stmt {
+func (p *parser) printStatement() stmt {
+	keyword := p.previous()
 	value := p.expression()
-	p.consume(Semicolon, "Expected ';' after value.")
-	return PrintStmt{value}
+	p.semicolon("Expected ';' after value.")
+	return &printStmt{keyword, value}
 }
 
-func (p *Parser) returnStatement() Stmt {
+func (p *parser) returnStatement() stmt {
 	keyword := p.previous()
-	var value Expr = nil
+	var value expr = nil
 	if !p.check(Semicolon) {
 		value = p.expression()
 	}
-	p.consume(Semicolon, "Expect ';' after return value.")
-	return ReturnStmt{keyword, value}
+	p.semicolon("Expect ';' after return value.")
+	return &returnStmt{keyword, value}
 }
 
-func (p *Parser) varDeclaration() Stmt {
+func (p *parser) throwStatement() stmt {
+	keyword := p.previous()
+	value := p.expression()
+	p.semicolon("Expect ';' after thrown value.")
+	return &throwStmt{keyword, value}
+}
+
+func (p *parser) tryStatement() stmt {
+	keyword := p.previous()
+	p.consume(LeftBrace, "Expect '{' after 'try'.")
+	body := &blockStmt{p.block(), 0}
+	var catchName *Token
+	var catch, finally *blockStmt
+	if p.match(Catch) {
+		p.consume(LeftParen, "Expect '(' after 'catch'.")
+		name := p.consume(Identifier, "Expect exception variable name.")
+		catchName = &name
+		p.consume(RightParen, "Expect ')' after exception variable name.")
+		p.consume(LeftBrace, "Expect '{' before catch body.")
+		catch = &blockStmt{p.block(), 0}
+	}
+	if p.match(Finally) {
+		p.consume(LeftBrace, "Expect '{' after 'finally'.")
+		finally = &blockStmt{p.block(), 0}
+	}
+	if catch == nil && finally == nil {
+		panic(parseError{p.peek(), "Expect 'catch' or 'finally' after try block.", nil})
+	}
+	return &tryStmt{keyword, body, catchName, catch, finally}
+}
+
+func (p *parser) varDeclaration() stmt {
 	name := p.consume(Identifier, "Expect variable name.")
 
-	var initializer Expr
+	var initializer expr
 	initializer = nil
 	if p.match(Equal) {
-		initializer = p.expression()
+		// A comma here would read as declaring a second variable, which
+		// isn't supported, so it is left to be reported.
+		initializer = p.assignment()
 	}
-	p.consume(Semicolon, "Expect ';' after variable declaration.")
-	return VariableStmt{name, initializer}
+	p.semicolon("Expect ';' after variable declaration.")
+	return &variableStmt{name, initializer, nil}
 }
 
-func (p *Parser) WhileStatement() Stmt {
-	p.consume(LeftParen, "Expect '(' after 'while'.")
-	condition := p.expression()
-	p.consume(RightParen, "Expect ')' after 'while'.")
+func (p *parser) WhileStatement(label *Token) stmt {
+	keyword := p.previous()
+	condition := p.condition("Expect ')' after 'while'.")
 	body := p.statement()
-	return WhileStmt{condition, body}
+	return &whileStmt{keyword, condition, body, nil, label}
 }
 
-func (p *Parser) expressionStatement() Stmt {
+func (p *parser) expressionStatement() stmt {
+	start := p.peek()
 	expr := p.expression()
-	p.consume(Semicolon, "Expect ';' after expression.")
-	return ExprStmt{expr}
+	p.semicolon("Expect ';' after expression.")
+	return &exprStmt{expr, start}
 }
 
-func (p *Parser) function(kind string) FunctionStmt {
-	name := p.consume(Identifier, "Expect"+kind+" name.")
-	p.consume(LeftParen, "Expect '(' after "+kind+"name.")
+// condition parses the parenthesized condition after if or while, which is
+// the previous token. Leaving out the parentheses is reported once and the
+// condition is parsed anyway, so the body is still checked.
+func (p *parser) condition(closeMessage string) expr {
+	keyword := p.previous()
+	open := p.match(LeftParen)
+	if !open {
+		p.error(p.peek(), "Expect '(' after '"+keyword.Lexeme+"'.", []Note{{
+			"The condition of '" + keyword.Lexeme + "' goes in parentheses, as in '" + keyword.Lexeme + " (condition)'.", tokenSpan(keyword),
+		}})
+	}
+	condition := p.expression()
+	p.checkCondition(condition)
+	if open {
+		p.consume(RightParen, closeMessage)
+	} else {
+		p.match(RightParen)
+	}
+	return condition
+}
+
+// checkCondition warns about a condition that assigns, which is usually a
+// comparison missing an '='. Parenthesizing the assignment says it's meant.
+func (p *parser) checkCondition(condition expr) {
+	var equals Token
+	switch condition := condition.(type) {
+	case *assignExpr:
+		equals = condition.Equals
+	case *setExpr:
+		equals = condition.Equals
+	case *indexSetExpr:
+		equals = condition.Equals
+	default:
+		return
+	}
+	p.diagnostics.Report(Diagnostic{
+		SeverityWarning, CodeAssignmentCondition,
+		"Assignment used as a condition, did you mean '=='? Wrap it in parentheses if the assignment is intended.",
+		tokenSpan(equals), nil, nil,
+	})
+}
+
+// semicolon consumes the ';' ending a statement. When it is missing at the end
+// of a line it is reported and parsing carries on as if it were there, rather
+// than throwing away the statement on the next line.
+func (p *parser) semicolon(message string) {
+	if p.match(Semicolon) {
+		return
+	}
+	if p.isAtEnd() || p.peek().Line > p.previous().Line {
+		p.error(p.peek(), message, []Note{{"Add a ';' after this.", tokenSpan(p.previous())}})
+		return
+	}
+	panic(parseError{p.peek(), message, nil})
+}
+
+func (p *parser) function(kind string) *functionStmt {
+	name := p.consume(Identifier, "Expect "+kind+" name.")
+	p.consume(LeftParen, "Expect '(' after "+kind+" name.")
+	parameters := p.parameters()
+	p.consume(LeftBrace, "Expect '{' before "+kind+" body.")
+	body := p.block()
+	return &functionStmt{name, parameters, body, nil, 0}
+
+}
+
+// lambda parses an anonymous function whose 'fun' was just consumed.
+func (p *parser) lambda() expr {
+	name := p.anonymous(p.previous())
+	p.consume(LeftParen, "Expect '(' after 'fun'.")
+	parameters := p.parameters()
+	p.consume(LeftBrace, "Expect '{' before function body.")
+	body := p.block()
+	return &functionExpr{&functionStmt{name, parameters, body, nil, 0}}
+}
+
+// arrow parses a function written as (a, b) => a + b, whose '(' was just
+// consumed. The body is a single expression that is returned.
+func (p *parser) arrow() expr {
+	parameters := p.parameters()
+	arrow := p.consume(Arrow, "Expect '=>' after parameters.")
+	body := []stmt{&returnStmt{arrow, p.assignment()}}
+	return &functionExpr{&functionStmt{p.anonymous(arrow), parameters, body, nil, 0}}
+}
+
+// anonymous is the name given to a function expression starting at token.
+func (p *parser) anonymous(token Token) Token {
+	token.Type, token.Lexeme, token.Literal = Identifier, "anonymous", nil
+	return token
+}
+
+// startsArrow reports whether the '(' at the current token opens the
+// parameters of an arrow function rather than a grouping.
+func (p *parser) startsArrow() bool {
+	distance := 1
+	if p.peekAt(distance).Type == Identifier {
+		distance++
+		for p.peekAt(distance).Type == Comma && p.peekAt(distance+1).Type == Identifier {
+			distance += 2
+		}
+	}
+	return p.peekAt(distance).Type == RightParen && p.peekAt(distance+1).Type == Arrow
+}
+
+// parameters parses a parameter list up to the closing ')'.
+func (p *parser) parameters() []Token {
 	var parameters []Token
 	if !p.check(RightParen) {
 		parameters = append(parameters, p.consume(Identifier, "Expect parameter name."))
 		for p.match(Comma) {
 			// NOTE: once again because of difference with do while
 			if len(parameters) >= 255 {
-				panic(ParseError{p.peek(), "Can't have more than 255 parameters."})
+				panic(parseError{p.peek(), "Can't have more than 255 parameters.", nil})
 			}
 			parameters = append(parameters, p.consume(Identifier, "Expect parameter name."))
 		}
 	}
 	p.consume(RightParen, "Expect ')' after parameters.")
-	p.consume(LeftBrace, "Expect '{' before "+kind+" body.")
-	body := p.block()
-	return FunctionStmt{name, parameters, body}
-
+	return parameters
 }
 
-func (p *Parser) block() []Stmt {
-	var statements []Stmt
+// block parses the statements of a block whose '{' was just consumed.
+func (p *parser) block() []stmt {
+	open := p.previous()
+	var statements []stmt
+	p.depth++
+	parens := p.parens
+	p.parens = 0
 	for !p.check(RightBrace) && !p.isAtEnd() {
 		statements = append(statements, p.declaration())
 	}
-	p.consume(RightBrace, "Expected '}' after block.")
+	p.parens = parens
+	p.depth--
+	p.closeBrace(open, "Expected '}' after block.")
 	return statements
 }
 
-func (p *Parser) assignment() Expr {
-	expr := p.or()
+// closeBrace consumes the '}' matching open. Running out of source first
+// is reported with a note at open, since the end of the file says nothing
+// about which brace was left unclosed.
+func (p *parser) closeBrace(open Token, message string) {
+	if !p.isAtEnd() {
+		p.consume(RightBrace, message)
+		return
+	}
+	if !p.unclosed {
+		p.unclosed = true
+		p.error(p.peek(), message, []Note{{"The '{' here is never closed.", tokenSpan(open)}})
+	}
+}
+
+func (p *parser) assignment() expr {
+	expr := p.conditional()
 	if p.match(Equal) {
 		equals := p.previous()
 		value := p.assignment()
-		varE, ok := expr.(VariableExpr)
+		varE, ok := expr.(*variableExpr)
 		if ok {
 			name := varE.Name
-			return AssignExpr{name, value}
+			return &assignExpr{name, equals, value, nil}
+		}
+		get, ok := expr.(*getExpr)
+		if ok {
+			return &setExpr{get.Object, get.Name, equals, value}
 		}
-		get, ok := expr.(GetExpr)
+		index, ok := expr.(*indexExpr)
 		if ok {
-			return SetExpr{get.Object, get.Name, value}
+			return &indexSetExpr{index.Object, index.Bracket, index.Index, equals, value}
 		}
-		panic(ParseError{equals, "Invalid assignment target."})
+		panic(parseError{equals, "Invalid assignment target.", []Note{{"Use '==' to compare two values.", tokenSpan(equals)}}})
+	}
+	if p.match(PlusEqual, MinusEqual, StarEqual, SlashEqual, PercentEqual, StarStarEqual,
+		AmpersandEqual, PipeEqual, CaretEqual, LessLessEqual, GreaterGreaterEqual) {
+		operator := p.previous()
+		value := p.assignment()
+		p.checkTarget(expr, operator)
+		operator.Type = compoundOperators[operator.Type]
+		return &updateExpr{expr, operator, value, false}
 	}
 	return expr
 
 }
 
-func (p *Parser) or() Expr {
+// conditional parses cond ? a : b. Like in C the middle operand can be any
+// expression, and the last one is another conditional so they nest to the
+// right.
+func (p *parser) conditional() expr {
+	expr := p.or()
+	if p.match(Question) {
+		question := p.previous()
+		thenBranch := p.expression()
+		if !p.match(Colon) {
+			panic(parseError{p.peek(), "Expect ':' after then branch of conditional expression.",
+				[]Note{{"The '?' here needs a matching ':'.", tokenSpan(question)}}})
+		}
+		elseBranch := p.conditional()
+		return &conditionalExpr{expr, question, thenBranch, elseBranch}
+	}
+	return expr
+}
+
+func (p *parser) or() expr {
 	expr := p.and()
 
 	for p.match(Or) {
 		operator := p.previous()
 		right := p.and()
-		expr = LogicalExpr{expr, operator, right}
+		expr = &logicalExpr{expr, operator, right}
 	}
 	return expr
 }
 
-func (p *Parser) and() Expr {
+func (p *parser) and() expr {
 	expr := p.equality()
 
 	for p.match(And) {
 		operator := p.previous()
 		right := p.equality()
-		expr = LogicalExpr{expr, operator, right}
+		expr = &logicalExpr{expr, operator, right}
 	}
 	return expr
 }
 
-func (p *Parser) equality() Expr {
+func (p *parser) equality() expr {
 	expr := p.comparison()
 	for p.match(BangEqual, EqualEqual) {
 		operator := p.previous()
 		right := p.comparison()
-		expr = BinaryExpr{expr, operator, right}
+		expr = &binaryExpr{expr, operator, right}
 	}
 	return expr
 }
 
-func (p *Parser) comparison() Expr {
-	expr := p.term()
+func (p *parser) comparison() expr {
+	expr := p.bitOr()
 	for p.match(Greater, GreaterEqual, Less, LessEqual) {
+		operator := p.previous()
+		right := p.bitOr()
+		expr = &binaryExpr{expr, operator, right}
+	}
+	return expr
+}
+
+// The bitwise operators bind tighter than comparisons, unlike in C, so
+// x & 1 == 0 compares the result of the &.
+func (p *parser) bitOr() expr {
+	expr := p.bitXor()
+	for p.match(Pipe) {
+		operator := p.previous()
+		right := p.bitXor()
+		expr = &binaryExpr{expr, operator, right}
+	}
+	return expr
+}
+
+func (p *parser) bitXor() expr {
+	expr := p.bitAnd()
+	for p.match(Caret) {
+		operator := p.previous()
+		right := p.bitAnd()
+		expr = &binaryExpr{expr, operator, right}
+	}
+	return expr
+}
+
+func (p *parser) bitAnd() expr {
+	expr := p.shift()
+	for p.match(Ampersand) {
+		operator := p.previous()
+		right := p.shift()
+		expr = &binaryExpr{expr, operator, right}
+	}
+	return expr
+}
+
+func (p *parser) shift() expr {
+	expr := p.term()
+	for p.match(LessLess, GreaterGreater) {
 		operator := p.previous()
 		right := p.term()
-		expr = BinaryExpr{expr, operator, right}
+		expr = &binaryExpr{expr, operator, right}
 	}
 	return expr
 }
 
-func (p *Parser) term() Expr {
+func (p *parser) term() expr {
 	expr := p.factor()
 	for p.match(Minus, Plus) {
 		operator := p.previous()
 		right := p.factor()
-		expr = BinaryExpr{expr, operator, right}
+		expr = &binaryExpr{expr, operator, right}
 	}
 	return expr
 }
 
-func (p *Parser) factor() Expr {
+func (p *parser) factor() expr {
 	expr := p.unary()
-	for p.match(Slash, Star) {
+	for p.match(Slash, Star, Percent) {
 		operator := p.previous()
 		right := p.unary()
-		expr = BinaryExpr{expr, operator, right}
+		expr = &binaryExpr{expr, operator, right}
 	}
 	return expr
 }
 
-func (p *Parser) unary() Expr {
+func (p *parser) unary() expr {
 	if p.match(Bang, Minus) {
 		operator := p.previous()
 		right := p.unary()
-		return UnaryExpr{operator, right}
+		return &unaryExpr{operator, right}
 	}
-	return p.call()
+	return p.power()
 }
 
-func (p *Parser) finishCall(callee Expr) Expr {
-	var arguments []Expr
+// power parses the ** operator. It binds tighter than a unary minus on its
+// left, so -2 ** 2 is -4, and is right associative since its right operand
+// is parsed with unary.
+func (p *parser) power() expr {
+	expr := p.increment()
+	if p.match(StarStar) {
+		operator := p.previous()
+		right := p.unary()
+		return &binaryExpr{expr, operator, right}
+	}
+	return expr
+}
+
+// increment parses ++ and -- before or after a variable, property or index.
+func (p *parser) increment() expr {
+	if p.match(PlusPlus, MinusMinus) {
+		operator := p.previous()
+		target := p.call()
+		return p.incrementOf(target, operator, false)
+	}
+	expr := p.call()
+	if p.match(PlusPlus, MinusMinus) {
+		return p.incrementOf(expr, p.previous(), true)
+	}
+	return expr
+}
+
+// incrementOf is target += 1 or target -= 1 for the ++ or -- operator.
+func (p *parser) incrementOf(target expr, operator Token, postfix bool) expr {
+	p.checkTarget(target, operator)
+	operator.Type = compoundOperators[operator.Type]
+	return &updateExpr{target, operator, &literalExpr{NumberValue(1)}, postfix}
+}
+
+// compoundOperators maps each compound assignment, ++ and -- to the binary
+// operator it applies.
+var compoundOperators = map[TokenType]TokenType{
+	PlusEqual:           Plus,
+	MinusEqual:          Minus,
+	StarEqual:           Star,
+	SlashEqual:          Slash,
+	PercentEqual:        Percent,
+	StarStarEqual:       StarStar,
+	AmpersandEqual:      Ampersand,
+	PipeEqual:           Pipe,
+	CaretEqual:          Caret,
+	LessLessEqual:       LessLess,
+	GreaterGreaterEqual: GreaterGreater,
+	PlusPlus:            Plus,
+	MinusMinus:          Minus,
+}
+
+// checkTarget reports an error unless target can be updated by operator.
+func (p *parser) checkTarget(target expr, operator Token) {
+	switch target.(type) {
+	case *variableExpr, *getExpr, *indexExpr:
+		return
+	}
+	panic(parseError{operator, "Can only apply '" + operator.Lexeme + "' to a variable, property or index.", nil})
+}
+
+func (p *parser) finishCall(callee expr) expr {
+	var arguments []expr
 	if !p.check(RightParen) {
 		p.match(Comma)
-		arguments = append(arguments, p.expression())
+		arguments = append(arguments, p.assignment())
 		for p.match(Comma) {
 			if len(arguments) >= 255 {
-				panic(ParseError{p.peek(), "Can't have more than 255 arguments."})
+				panic(parseError{p.peek(), "Can't have more than 255 arguments.", nil})
 			}
-			arguments = append(arguments, p.expression())
+			arguments = append(arguments, p.assignment())
 			//NOTE: in the java version we do 255 but here we aren't doing a do while so its 254
 		}
 	}
 	paren := p.consume(RightParen, "Expect ')' after arguments.")
-	return CallExpr{callee, paren, arguments}
+	return &callExpr{callee, paren, arguments}
 
 }
 
-func (p *Parser) call() Expr {
+func (p *parser) call() expr {
 	expr := p.primary()
 
 	for {
@@ -337,7 +719,11 @@ func (p *Parser) call() Expr {
 			expr = p.finishCall(expr)
 		} else if p.match(Dot) {
 			name := p.consume(Identifier, "Expect property name after '.'.")
-			expr = GetExpr{expr, name}
+			expr = &getExpr{expr, name}
+		} else if p.match(LeftBracket) {
+			index := p.expression()
+			bracket := p.consume(RightBracket, "Expect ']' after index.")
+			expr = &indexExpr{expr, bracket, index}
 		} else {
 			break
 		}
@@ -345,42 +731,126 @@ func (p *Parser) call() Expr {
 	return expr
 }
 
-func (p *Parser) primary() Expr {
+func (p *parser) primary() expr {
 	if p.match(False) {
-		return LiteralExpr{false}
+		return &literalExpr{BoolValue(false)}
 	}
 	if p.match(True) {
-		return LiteralExpr{true}
+		return &literalExpr{BoolValue(true)}
 	}
 	if p.match(Nil) {
-		return LiteralExpr{nil}
+		return &literalExpr{Value{}}
 	}
-	if p.match(Number, String) {
-		return LiteralExpr{p.previous().Literal}
+	if p.match(Number) {
+		return &literalExpr{NumberValue(p.previous().Literal.(float64))}
+	}
+	if p.match(String) {
+		return &literalExpr{StringValue(p.previous().Literal.(string))}
+	}
+	if p.match(Interpolation) {
+		return p.interpolation()
 	}
 	if p.match(Super) {
 		keyword := p.previous()
 		p.consume(Dot, "Expect '.' after 'super'.")
 		method := p.consume(Identifier, "Expect superclass method name.")
-		return SuperExpr{keyword, method}
+		return &superExpr{keyword, method, nil}
 	}
 	if p.match(This) {
-		return ThisExpr{p.previous()}
+		return &thisExpr{p.previous(), nil}
 	}
 	if p.match(Identifier) {
-		return VariableExpr{p.previous()}
+		return &variableExpr{p.previous(), nil}
+	}
+	if p.match(Fun) {
+		return p.lambda()
+	}
+	if p.check(LeftParen) && p.startsArrow() {
+		p.advance()
+		return p.arrow()
 	}
 	if p.match(LeftParen) {
 		expr := p.expression()
 		p.consume(RightParen, "Expect ')' after expression.")
-		return GroupingExpr{expr}
+		return &groupingExpr{expr}
+	}
+	if p.match(LeftBracket) {
+		return p.list()
+	}
+	if p.match(LeftBrace) {
+		return p.mapLiteral()
+	}
+	_, keyword := keywords[p.peek().Lexeme]
+	if keyword && p.peekNext().Type == LeftParen {
+		panic(parseError{p.peek(), "Can't call '" + p.peek().Lexeme + "' because it is a keyword.", nil})
 	}
-	panic(ParseError{p.peek(), "Expect expression."})
-	return nil
+	panic(parseError{p.peek(), "Expect expression.", nil})
 
 }
 
-func (p *Parser) match(types ...TokenType) bool {
+// interpolation parses a string with expressions in it, whose first part was
+// just consumed, into the concatenation of its parts.
+func (p *parser) interpolation() expr {
+	plus := p.previous()
+	plus.Type, plus.Lexeme, plus.Literal = Plus, "+", nil
+	var parts []expr
+	for {
+		text := p.previous().Literal.(string)
+		if text != "" {
+			parts = append(parts, &literalExpr{StringValue(text)})
+		}
+		if p.previous().Type == String {
+			break
+		}
+		parts = append(parts, &stringifyExpr{p.expression()})
+		p.consume(RightBrace, "Expect '}' after interpolated expression.")
+		if !p.match(Interpolation, String) {
+			panic(parseError{p.peek(), "Expect rest of string after interpolated expression.", nil})
+		}
+	}
+	expr := parts[0]
+	for _, part := range parts[1:] {
+		expr = &binaryExpr{expr, plus, part}
+	}
+	return expr
+}
+
+// list parses the elements of a list literal whose '[' was just consumed. A
+// trailing comma is allowed so long lists can be written one element a line.
+func (p *parser) list() expr {
+	var elements []expr
+	for !p.check(RightBracket) {
+		elements = append(elements, p.assignment())
+		if !p.match(Comma) {
+			break
+		}
+	}
+	bracket := p.consume(RightBracket, "Expect ']' after list elements.")
+	return &listExpr{bracket, elements}
+}
+
+// mapLiteral parses the entries of a map literal whose '{' was just consumed.
+// An identifier key is a string, as in {name: "lox"}, other keys are
+// expressions.
+func (p *parser) mapLiteral() expr {
+	var keys, values []expr
+	for !p.check(RightBrace) {
+		if p.check(Identifier) && p.peekNext().Type == Colon {
+			keys = append(keys, &literalExpr{StringValue(p.advance().Lexeme)})
+		} else {
+			keys = append(keys, p.assignment())
+		}
+		p.consume(Colon, "Expect ':' after map key.")
+		values = append(values, p.assignment())
+		if !p.match(Comma) {
+			break
+		}
+	}
+	brace := p.consume(RightBrace, "Expect '}' after map entries.")
+	return &mapExpr{brace, keys, values}
+}
+
+func (p *parser) match(types ...TokenType) bool {
 	for _, t := range types {
 		if p.check(t) {
 			p.advance()
@@ -390,49 +860,116 @@ func (p *Parser) match(types ...TokenType) bool {
 	return false
 }
 
-func (p *Parser) consume(t TokenType, message string) Token {
+func (p *parser) consume(t TokenType, message string) Token {
 	if p.check(t) {
 		return p.advance()
 	}
-	panic(ParseError{p.peek(), message})
-	return Token{}
+	panic(parseError{p.peek(), message, nil})
 
 }
 
-func (p *Parser) check(t TokenType) bool {
+func (p *parser) check(t TokenType) bool {
 	if p.isAtEnd() {
 		return false
 	}
 	return p.peek().Type == t
 }
 
-func (p *Parser) advance() Token {
+func (p *parser) advance() Token {
 	if !p.isAtEnd() {
+		switch p.peek().Type {
+		case LeftParen:
+			p.parens++
+		case RightParen:
+			p.parens = max(p.parens-1, 0)
+		}
 		p.current++
 	}
 	return p.previous()
 }
 
-func (p *Parser) isAtEnd() bool {
+func (p *parser) isAtEnd() bool {
 	return p.peek().Type == EOF
 }
 
-func (p *Parser) peek() Token {
+func (p *parser) peek() Token {
 	return *p.Tokens[p.current]
 }
-func (p *Parser) previous() Token {
+
+// peekNext is the token after peek, or the EOF token at the end.
+func (p *parser) peekNext() Token {
+	if p.isAtEnd() {
+		return p.peek()
+	}
+	return *p.Tokens[p.current+1]
+}
+
+// peekAt is the token distance places after peek, or the EOF token if the
+// source ends first.
+func (p *parser) peekAt(distance int) Token {
+	return *p.Tokens[min(p.current+distance, len(p.Tokens)-1)]
+}
+
+func (p *parser) previous() Token {
 	return *p.Tokens[p.current-1]
 }
 
-func (p *Parser) synchronize() {
-	p.advance()
-	for !p.isAtEnd() {
-		if p.previous().Type == Semicolon {
-			return
-		}
+func (p *parser) error(t Token, message string, notes []Note) {
+	p.diagnostics.Report(Diagnostic{SeverityError, CodeSyntax, message, tokenSpan(t), notes, nil})
+}
+
+// recoverError is deferred by the productions that can carry on after a
+// syntax error: it reports the parseError and skips to the next statement.
+func (p *parser) recoverError() {
+	//NOTE: this is golang try catching :)
+	recovered := recover()
+	err, ok := recovered.(parseError)
+	if ok {
+		p.error(err.Token, err.Messge, err.Notes)
+		p.synchronize()
+		return
+	}
+	//STILL WANT TO PANIC OTHERWISE
+	if recovered != nil {
+		panic(recovered)
+	}
+}
+
+// synchronize discards tokens until the start of the next statement: just
+// past a ';', before a keyword that begins a statement, or before the '}'
+// closing the enclosing block. A braced body met on the way belongs to the
+// broken statement and is skipped whole, and so does a ';' inside open
+// parentheses. The token the error was at is always skipped unless it is
+// that '}', which guarantees progress.
+func (p *parser) synchronize() {
+	defer func() {
+		p.parens = 0
+	}()
+	nested := 0
+	for first := true; !p.isAtEnd(); first = false {
 		switch p.peek().Type {
-		case Class | Fun | Var | For | If | While | Print | Return:
-			return
+		case Semicolon:
+			if nested == 0 && p.parens == 0 {
+				p.advance()
+				return
+			}
+		case Class, Fun, Var, For, If, While, Print, Return, Break, Continue, Throw, Try:
+			if nested == 0 && !first {
+				return
+			}
+		case LeftBrace:
+			nested++
+		case RightBrace:
+			if nested == 0 && p.depth > 0 {
+				return
+			}
+			if nested > 0 {
+				nested--
+				if nested == 0 {
+					p.advance()
+					return
+				}
+			}
 		}
 		p.advance()
 	}
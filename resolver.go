@@ -1,67 +1,99 @@
-package main
+package lox
 
 import (
-	"github.com/jastintime/lox/ClassType"
-	"github.com/jastintime/lox/FunctionType"
+	"github.com/jastintime/lox/internal/ClassType"
+	"github.com/jastintime/lox/internal/FunctionType"
 )
 
-type Resolver struct {
-	interpreter     Interpreter
-	scopes          Stack[map[string]bool]
+type resolver struct {
+	interpreter     *Interpreter
+	scopes          stack[map[string]*local]
 	currentFunction functionType.FunctionType
 	currentClass    classType.ClassType
+	// loops are the loops enclosing the current statement within the
+	// current function, innermost last.
+	loops       []*whileStmt
+	diagnostics DiagnosticSink
+	// globals are the names the program declares at the top level, which
+	// functions may use before the declaration runs.
+	globals map[string]bool
 }
 
-func newResolver(interpreter Interpreter) Resolver {
-	var stack = Stack[map[string]bool]{}
-	return Resolver{interpreter, stack, functionType.None, classType.None}
+// local is a variable declared in one of the resolver's scopes, slot is its
+// index in the environment the interpreter creates for that scope.
+type local struct {
+	name    Token
+	defined bool
+	slot    int
 }
 
-func (r Resolver) resolve(a any) {
+func newResolver(interpreter *Interpreter, diagnostics DiagnosticSink) resolver {
+	var stack = stack[map[string]*local]{}
+	return resolver{interpreter, stack, functionType.None, classType.None, nil, diagnostics, make(map[string]bool)}
+}
+
+// resolveProgram resolves a whole script, noting its globals first so a
+// function can refer to one declared further down.
+func (r resolver) resolveProgram(statements []stmt) {
+	for _, statement := range statements {
+		switch statement := statement.(type) {
+		case *variableStmt:
+			r.globals[statement.Name.Lexeme] = true
+		case *functionStmt:
+			r.globals[statement.Name.Lexeme] = true
+		case *classStmt:
+			r.globals[statement.Name.Lexeme] = true
+		}
+	}
+	r.resolve(statements)
+}
+
+func (r resolver) resolve(a any) {
 	switch arg := a.(type) {
-	case []Stmt:
+	case []stmt:
 		for _, statement := range arg {
 			r.resolve(statement)
 		}
-	case Stmt:
-		arg.Accept(r)
-	case Expr:
-		arg.Accept(r)
+	case stmt:
+		arg.accept(r)
+	case expr:
+		arg.accept(r)
 	default:
 		panic("unexpected resolve")
 	}
 }
 
-func (r Resolver) VisitBlockStmt(stmt BlockStmt) any {
+func (r resolver) visitBlockStmt(stmt *blockStmt) any {
 	r.beginScope()
 	r.resolve(stmt.Statements)
-	r.endScope()
+	r.endScope(&stmt.size)
 	return nil
 }
 
-func (r Resolver) VisitClassStmt(stmt ClassStmt) any {
+func (r resolver) visitClassStmt(stmt *classStmt) any {
 	enclosingClass := r.currentClass
 	r.currentClass = classType.Class
 
 	r.declare(stmt.Name)
 	r.define(stmt.Name)
+	r.declaration(&stmt.local, stmt.Name)
 
 	if stmt.Superclass != nil && stmt.Name.Lexeme == stmt.Superclass.Name.Lexeme {
-		emitTokenError(stmt.Superclass.Name, "A class can't inherit from itself.")
+		r.error(stmt.Superclass.Name, CodeInheritFromSelf, "A class can't inherit from itself.")
 	}
 
 	if stmt.Superclass != nil {
 		r.currentClass = classType.Subclass
-		r.resolve(*stmt.Superclass)
+		r.resolve(stmt.Superclass)
 	}
 
 	if stmt.Superclass != nil {
 		r.beginScope()
-		r.scopes.Peek()["super"] = true
+		r.scopes.Peek()["super"] = &local{defined: true}
 	}
 
 	r.beginScope()
-	r.scopes.Peek()["this"] = true
+	r.scopes.Peek()["this"] = &local{defined: true}
 
 	for _, method := range stmt.Methods {
 		declaration := functionType.Method
@@ -71,28 +103,32 @@ func (r Resolver) VisitClassStmt(stmt ClassStmt) any {
 
 		r.resolveFunction(method, declaration)
 	}
+	for _, method := range stmt.StaticMethods {
+		r.resolveFunction(method, functionType.Method)
+	}
 
-	r.endScope()
+	r.endScope(nil)
 	if stmt.Superclass != nil {
-		r.endScope()
+		r.endScope(nil)
 	}
 	r.currentClass = enclosingClass
 	return nil
 }
 
-func (r Resolver) VisitExprStmt(stmt ExprStmt) any {
+func (r resolver) visitExprStmt(stmt *exprStmt) any {
 	r.resolve(stmt.Expression)
 	return nil
 }
 
-func (r Resolver) VisitFunctionStmt(stmt FunctionStmt) any {
+func (r resolver) visitFunctionStmt(stmt *functionStmt) any {
 	r.declare(stmt.Name)
 	r.define(stmt.Name)
+	r.declaration(&stmt.local, stmt.Name)
 	r.resolveFunction(stmt, functionType.Function)
 	return nil
 }
 
-func (r Resolver) VisitIfStmt(stmt IfStmt) any {
+func (r resolver) visitIfStmt(stmt *ifStmt) any {
 	r.resolve(stmt.Condition)
 	r.resolve(stmt.ThenBranch)
 	if stmt.ElseBranch != nil {
@@ -101,18 +137,18 @@ func (r Resolver) VisitIfStmt(stmt IfStmt) any {
 	return nil
 }
 
-func (r Resolver) VisitPrintStmt(stmt PrintStmt) any {
+func (r resolver) visitPrintStmt(stmt *printStmt) any {
 	r.resolve(stmt.Expression)
 	return nil
 }
 
-func (r Resolver) VisitReturnStmt(stmt ReturnStmt) any {
+func (r resolver) visitReturnStmt(stmt *returnStmt) any {
 	if r.currentFunction == functionType.None {
-		emitTokenError(stmt.Keyword, "Can't return from top-level code.")
+		r.error(stmt.Keyword, CodeTopLevelReturn, "Can't return from top-level code.")
 	}
 	if stmt.Value != nil {
 		if r.currentFunction == functionType.Initializer {
-			emitTokenError(stmt.Keyword, "Can't return a value from an initializer.")
+			r.error(stmt.Keyword, CodeInitializerReturn, "Can't return a value from an initializer.")
 		}
 
 		r.resolve(stmt.Value)
@@ -120,151 +156,333 @@ func (r Resolver) VisitReturnStmt(stmt ReturnStmt) any {
 	return nil
 }
 
-func (r Resolver) VisitVariableStmt(stmt VariableStmt) any {
+func (r resolver) visitVariableStmt(stmt *variableStmt) any {
 	r.declare(stmt.Name)
 	if stmt.Initializer != nil {
 		r.resolve(stmt.Initializer)
 	}
 	r.define(stmt.Name)
+	r.declaration(&stmt.local, stmt.Name)
 	return nil
 }
 
-func (r Resolver) VisitWhileStmt(stmt WhileStmt) any {
+func (r resolver) visitWhileStmt(stmt *whileStmt) any {
 	r.resolve(stmt.Condition)
+	enclosingLoops := r.loops
+	r.loops = append(r.loops, stmt)
 	r.resolve(stmt.Body)
+	r.loops = enclosingLoops
+	if stmt.Increment != nil {
+		r.resolve(stmt.Increment)
+	}
 	return nil
 }
 
-func (r Resolver) VisitAssignExpr(expr AssignExpr) any {
-	r.resolve(expr.Value)
-	r.resolveLocal(expr, expr.Name)
+func (r resolver) visitThrowStmt(stmt *throwStmt) any {
+	r.resolve(stmt.Value)
+	return nil
+}
+
+// visitTryStmt gives the exception variable slot 0 of a scope shared with the
+// catch body, the way parameters share a scope with a function's body.
+func (r resolver) visitTryStmt(stmt *tryStmt) any {
+	r.resolve(stmt.Body)
+	if stmt.Catch != nil {
+		r.beginScope()
+		r.declare(*stmt.CatchName)
+		r.define(*stmt.CatchName)
+		r.resolve(stmt.Catch.Statements)
+		r.endScope(&stmt.Catch.size)
+	}
+	if stmt.Finally != nil {
+		r.resolve(stmt.Finally)
+	}
+	return nil
+}
+
+func (r resolver) visitBreakStmt(stmt *breakStmt) any {
+	r.resolveJump(stmt.Keyword, stmt.Label)
+	return nil
+}
+
+func (r resolver) visitContinueStmt(stmt *continueStmt) any {
+	r.resolveJump(stmt.Keyword, stmt.Label)
 	return nil
 }
 
-func (r Resolver) VisitBinaryExpr(expr BinaryExpr) any {
+func (r resolver) visitAssignExpr(expr *assignExpr) Value {
+	r.resolve(expr.Value)
+	r.resolveLocal(&expr.local, expr.Name)
+	return Value{}
+}
+
+func (r resolver) visitBinaryExpr(expr *binaryExpr) Value {
 	r.resolve(expr.Left)
 	r.resolve(expr.Right)
-	return nil
+	return Value{}
 }
 
-func (r Resolver) VisitCallExpr(expr CallExpr) any {
+func (r resolver) visitCallExpr(expr *callExpr) Value {
 	r.resolve(expr.Callee)
 	for _, argument := range expr.Arguments {
 		r.resolve(argument)
 	}
-	return nil
+	return Value{}
 }
 
-func (r Resolver) VisitGetExpr(expr GetExpr) any {
+func (r resolver) visitGetExpr(expr *getExpr) Value {
 	r.resolve(expr.Object)
-	return nil
+	return Value{}
+}
+
+func (r resolver) visitIndexExpr(expr *indexExpr) Value {
+	r.resolve(expr.Object)
+	r.resolve(expr.Index)
+	return Value{}
+}
+
+func (r resolver) visitIndexSetExpr(expr *indexSetExpr) Value {
+	r.resolve(expr.Object)
+	r.resolve(expr.Index)
+	r.resolve(expr.Value)
+	return Value{}
+}
+
+func (r resolver) visitListExpr(expr *listExpr) Value {
+	for _, element := range expr.Elements {
+		r.resolve(element)
+	}
+	return Value{}
+}
+
+func (r resolver) visitMapExpr(expr *mapExpr) Value {
+	for k := range expr.Keys {
+		r.resolve(expr.Keys[k])
+		r.resolve(expr.Values[k])
+	}
+	return Value{}
 }
 
-func (r Resolver) VisitGroupingExpr(expr GroupingExpr) any {
+func (r resolver) visitGroupingExpr(expr *groupingExpr) Value {
 	r.resolve(expr.Expression)
-	return nil
+	return Value{}
 }
 
-func (r Resolver) VisitLiteralExpr(expr LiteralExpr) any {
-	return nil
+func (r resolver) visitConditionalExpr(expr *conditionalExpr) Value {
+	r.resolve(expr.Condition)
+	r.resolve(expr.ThenBranch)
+	r.resolve(expr.ElseBranch)
+	return Value{}
 }
 
-func (r Resolver) VisitLogicalExpr(expr LogicalExpr) any {
+func (r resolver) visitCommaExpr(expr *commaExpr) Value {
 	r.resolve(expr.Left)
 	r.resolve(expr.Right)
-	return nil
+	return Value{}
+}
+
+func (r resolver) visitUpdateExpr(expr *updateExpr) Value {
+	r.resolve(expr.Target)
+	r.resolve(expr.Value)
+	return Value{}
+}
+
+func (r resolver) visitFunctionExpr(expr *functionExpr) Value {
+	r.resolveFunction(expr.Function, functionType.Function)
+	return Value{}
+}
+
+func (r resolver) visitStringifyExpr(expr *stringifyExpr) Value {
+	r.resolve(expr.Expression)
+	return Value{}
+}
+
+func (r resolver) visitLiteralExpr(expr *literalExpr) Value {
+	return Value{}
 }
 
-func (r Resolver) VisitSetExpr(expr SetExpr) any {
+func (r resolver) visitLogicalExpr(expr *logicalExpr) Value {
+	r.resolve(expr.Left)
+	r.resolve(expr.Right)
+	return Value{}
+}
+
+func (r resolver) visitSetExpr(expr *setExpr) Value {
 	r.resolve(expr.Value)
 	r.resolve(expr.Object)
-	return nil
+	return Value{}
 }
 
-func (r Resolver) VisitSuperExpr(expr SuperExpr) any {
+func (r resolver) visitSuperExpr(expr *superExpr) Value {
 	if r.currentClass == classType.None {
-		emitTokenError(expr.Keyword, "Can't use 'super' outside of a class.")
+		r.error(expr.Keyword, CodeSuperOutsideClass, "Can't use 'super' outside of a class.")
 	} else if r.currentClass != classType.Subclass {
-		emitTokenError(expr.Keyword, "Can't use 'super' in a class with no superclass.")
+		r.error(expr.Keyword, CodeSuperWithoutSuperclass, "Can't use 'super' in a class with no superclass.")
 	}
 
-	r.resolveLocal(expr, expr.Keyword)
-	return nil
+	r.resolveLocal(&expr.local, expr.Keyword)
+	return Value{}
 }
 
-func (r Resolver) VisitThisExpr(expr ThisExpr) any {
+func (r resolver) visitThisExpr(expr *thisExpr) Value {
 	if r.currentClass == classType.None {
-		emitTokenError(expr.Keyword, "Can't use 'this' outside of a class.")
-		return nil
+		r.error(expr.Keyword, CodeThisOutsideClass, "Can't use 'this' outside of a class.")
+		return Value{}
 	}
-	r.resolveLocal(expr, expr.Keyword)
-	return nil
+	r.resolveLocal(&expr.local, expr.Keyword)
+	return Value{}
 }
 
-func (r Resolver) VisitUnaryExpr(expr UnaryExpr) any {
+func (r resolver) visitUnaryExpr(expr *unaryExpr) Value {
 	r.resolve(expr.Right)
-	return nil
+	return Value{}
 }
 
-func (r Resolver) VisitVariableExpr(expr VariableExpr) any {
+func (r resolver) visitVariableExpr(expr *variableExpr) Value {
 	if !r.scopes.IsEmpty() {
 		variable, inScope := r.scopes.Peek()[expr.Name.Lexeme]
-		if inScope && variable == false {
-			emitTokenError(expr.Name, "Can't read local variable in its own initializer.")
+		if inScope && !variable.defined {
+			r.error(expr.Name, CodeOwnInitializer, "Can't read local variable in its own initializer.")
 		}
 	}
-	r.resolveLocal(expr, expr.Name)
-	return nil
+	r.resolveLocal(&expr.local, expr.Name)
+	return Value{}
 }
 
-func (r Resolver) resolveFunction(function FunctionStmt, t functionType.FunctionType) any {
+func (r resolver) resolveFunction(function *functionStmt, t functionType.FunctionType) any {
 	enclosingFunction := r.currentFunction
+	enclosingLoops := r.loops
 	r.currentFunction = t
+	r.loops = nil
 	r.beginScope()
 	for _, param := range function.Params {
 		r.declare(param)
 		r.define(param)
 	}
 	r.resolve(function.Body)
-	r.endScope()
+	r.endScope(&function.size)
 	r.currentFunction = enclosingFunction
+	r.loops = enclosingLoops
 	return nil
 }
 
-func (r *Resolver) beginScope() {
-	r.scopes.Push(make(map[string]bool))
+func (r *resolver) beginScope() {
+	r.scopes.Push(make(map[string]*local))
 }
 
-func (r *Resolver) endScope() {
-	r.scopes.Pop()
+// endScope pops the innermost scope. If an environment is created for it,
+// size is set to how many slots that environment needs.
+func (r *resolver) endScope(size *int) {
+	scope := r.scopes.Pop()
+	if size != nil {
+		*size = len(scope)
+	}
 }
 
-func (r *Resolver) declare(name Token) {
+func (r *resolver) declare(name Token) {
 	if r.scopes.IsEmpty() {
 		return
 	}
 	scope := r.scopes.Peek()
-	_, dup := scope[name.Lexeme]
+	previous, dup := scope[name.Lexeme]
+	if dup {
+		r.diagnostics.Report(Diagnostic{
+			SeverityError, CodeRedeclaration, "Already a variable with this name in this scope.", tokenSpan(name),
+			[]Note{{"'" + name.Lexeme + "' was first declared here.", tokenSpan(previous.name)}}, nil,
+		})
+	}
+
+	slot := len(scope)
 	if dup {
-		emitTokenError(name, "Already a variable with this name in this scope.")
+		slot = previous.slot
 	}
+	scope[name.Lexeme] = &local{name, false, slot}
+}
 
-	scope[name.Lexeme] = false
+func (r *resolver) define(name Token) {
+	if r.scopes.IsEmpty() {
+		return
+	}
+	r.scopes.Peek()[name.Lexeme].defined = true
 }
 
-func (r *Resolver) define(name Token) {
+// declaration records which slot a declaration defines name in, unless it is
+// global.
+func (r *resolver) declaration(local **binding, name Token) {
 	if r.scopes.IsEmpty() {
 		return
 	}
-	r.scopes.Peek()[name.Lexeme] = true
+	*local = &binding{0, r.scopes.Peek()[name.Lexeme].slot}
+}
+
+// resolveLocal records where the variable name refers to lives, unless it is
+// global.
+func (r *resolver) resolveLocal(local **binding, name Token) {
+	for i := r.scopes.Size() - 1; i >= 0; i-- {
+		variable, ok := r.scopes.Get(i)[name.Lexeme]
+		if ok {
+			*local = &binding{r.scopes.Size() - 1 - i, variable.slot}
+			return
+		}
+	}
+	r.checkGlobal(name)
 }
 
-func (r *Resolver) resolveLocal(expr Expr, name Token) {
+// checkGlobal warns when a name inside a function or block is left to be looked
+// up as a global that doesn't exist, but is close to the name of a local. That
+// is almost always a typo that would fail as soon as the code ran.
+func (r *resolver) checkGlobal(name Token) {
+	if r.scopes.IsEmpty() || r.globals[name.Lexeme] {
+		return
+	}
+	if _, ok := r.interpreter.globals.values[name.Lexeme]; ok {
+		return
+	}
+	locals := func(yield func(string) bool) {
+		for i := range r.scopes.Size() {
+			for lexeme, variable := range r.scopes.Get(i) {
+				if variable.name.Lexeme != "" && !yield(lexeme) {
+					return
+				}
+			}
+		}
+	}
+	suggestion := suggest(name.Lexeme, locals)
+	if suggestion == "" {
+		return
+	}
+	var declared Token
 	for i := r.scopes.Size() - 1; i >= 0; i-- {
-		_, ok := r.scopes.Get(i)[name.Lexeme]
+		variable, ok := r.scopes.Get(i)[suggestion]
 		if ok {
-			r.interpreter.Resolve(expr, r.scopes.Size()-1-i)
+			declared = variable.name
+			break
+		}
+	}
+	r.diagnostics.Report(Diagnostic{
+		SeverityWarning, CodeUndefinedVariable, undefined("variable", name.Lexeme, locals), tokenSpan(name),
+		[]Note{{"'" + suggestion + "' is declared here.", tokenSpan(declared)}}, nil,
+	})
+}
+
+// resolveJump checks a break or continue has a loop to jump out of, and that
+// its label names one of the loops around it.
+func (r *resolver) resolveJump(keyword Token, label *Token) {
+	if len(r.loops) == 0 {
+		r.error(keyword, CodeOutsideLoop, "Can't use '"+keyword.Lexeme+"' outside of a loop.")
+		return
+	}
+	if label == nil {
+		return
+	}
+	for _, loop := range r.loops {
+		if loop.Label != nil && loop.Label.Lexeme == label.Lexeme {
 			return
 		}
 	}
+	r.error(*label, CodeUndefinedLabel, "No enclosing loop is labeled '"+label.Lexeme+"'.")
+}
+
+func (r *resolver) error(t Token, code Code, message string) {
+	r.diagnostics.Report(Diagnostic{SeverityError, code, message, tokenSpan(t), nil, nil})
 }
@@ -1,6 +1,104 @@
-package main
+package lox
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 type RuntimeError struct {
 	Token   Token
 	Message string
+	// Trace is the Lox call stack when the error was raised, innermost call
+	// first. It is filled in as the error leaves Run.
+	Trace []TraceFrame
+	// Value is what a throw statement threw, nil for errors raised by the
+	// interpreter itself. Those are caught as an instance of Error.
+	Value Value
+}
+
+// ThrowValue returns an error that makes a native function throw value, as if the
+// script had run a throw statement at the call. Any other error a native
+// returns is thrown as an Error carrying its message.
+func ThrowValue(value Value) error {
+	return RuntimeError{Token{}, value.String(), nil, value}
+}
+
+// nativeError is the RuntimeError a native returning err raises at the call.
+func nativeError(paren Token, err error) RuntimeError {
+	var thrown RuntimeError
+	if errors.As(err, &thrown) && !thrown.Value.IsNil() {
+		return RuntimeError{paren, thrown.Message, nil, thrown.Value}
+	}
+	return RuntimeError{paren, err.Error(), nil, Value{}}
+}
+
+// newThrow is the RuntimeError a throw statement at keyword raises for value.
+// fields are value's fields if it is an instance. An Error is stamped with
+// where it was first thrown, and its message becomes the error's.
+func newThrow(keyword Token, value Value, trace []TraceFrame, fields map[string]Value, isError bool) RuntimeError {
+	err := RuntimeError{keyword, value.String(), trace, value}
+	if isError {
+		if _, ok := fields["line"]; !ok {
+			fields["line"] = NumberValue(float64(keyword.Line))
+			fields["trace"] = StringValue(formatTrace(trace))
+		}
+	}
+	if message, ok := fields["message"]; ok {
+		err.Message = message.String()
+	}
+	return err
+}
+
+// errorFields are the fields of the Error instance a catch clause receives
+// for an error the interpreter raised.
+func (e RuntimeError) errorFields() map[string]Value {
+	return map[string]Value{
+		"message": StringValue(e.Message),
+		"line":    NumberValue(float64(e.Token.Line)),
+		"trace":   StringValue(formatTrace(e.Trace)),
+	}
+}
+
+func (e RuntimeError) Error() string {
+	return e.Diagnostic().String()
+}
+
+func (e RuntimeError) Diagnostic() Diagnostic {
+	return Diagnostic{SeverityError, CodeRuntime, e.Message, tokenSpan(e.Token), nil, e.Trace}
+}
+
+// TraceFrame is one call on the stack of a RuntimeError. Line is the line
+// the call was executing, or the line of the error for the innermost frame.
+type TraceFrame struct {
+	// Function is the name of the function, method or class called, empty
+	// for the top level of the script.
+	Function string `json:"function,omitempty"`
+	// Class is the class a method belongs to.
+	Class  string `json:"class,omitempty"`
+	Line   int    `json:"line"`
+	Native bool   `json:"native,omitempty"`
+}
+
+func formatTrace(trace []TraceFrame) string {
+	lines := make([]string, len(trace))
+	for k, frame := range trace {
+		lines[k] = frame.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// String formats the frame the way clox prints its stack traces.
+func (f TraceFrame) String() string {
+	name := f.Function
+	if f.Class != "" {
+		name = f.Class + "." + name
+	}
+	switch {
+	case f.Function == "":
+		return fmt.Sprintf("[line %d] in script", f.Line)
+	case f.Native:
+		return fmt.Sprintf("[native] in %s()", name)
+	}
+	return fmt.Sprintf("[line %d] in %s()", f.Line, name)
 }
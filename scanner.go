@@ -1,41 +1,54 @@
-package main
+package lox
 
 import (
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
-type Scanner struct {
-	source  []rune // rune slice! support utf8 strings!! no utf8 identifiers tho :sob:
-	Tokens  []*Token
-	start   int
-	current int
-	line    int
+type scanner struct {
+	source      string // byte offsets, but advance decodes utf8 so strings still work. no utf8 identifiers tho :sob:
+	Tokens      []*Token
+	start       int
+	current     int
+	line        int
+	file        *sourceFile
+	diagnostics DiagnosticSink
+	// interpolations counts the braces open inside each "${" being scanned,
+	// innermost last, so the '}' that ends it can be told apart.
+	interpolations []int
 }
 
 var keywords = map[string]TokenType{
-	"and":    And,
-	"class":  Class,
-	"else":   Else,
-	"false":  False,
-	"for":    For,
-	"fun":    Fun,
-	"if":     If,
-	"nil":    Nil,
-	"or":     Or,
-	"print":  Print,
-	"return": Return,
-	"super":  Super,
-	"this":   This,
-	"true":   True,
-	"var":    Var,
-	"while":  While,
-}
-
-func newScanner(source string) *Scanner {
-	return &Scanner{[]rune(source), make([]*Token, 0), 0, 0, 1}
-}
-
-func (s *Scanner) ScanTokens() []*Token {
+	"and":      And,
+	"break":    Break,
+	"catch":    Catch,
+	"class":    Class,
+	"continue": Continue,
+	"else":     Else,
+	"false":    False,
+	"finally":  Finally,
+	"for":      For,
+	"fun":      Fun,
+	"if":       If,
+	"nil":      Nil,
+	"or":       Or,
+	"print":    Print,
+	"return":   Return,
+	"super":    Super,
+	"this":     This,
+	"throw":    Throw,
+	"true":     True,
+	"try":      Try,
+	"var":      Var,
+	"while":    While,
+}
+
+func newScanner(source string, diagnostics DiagnosticSink) *scanner {
+	return &scanner{source, make([]*Token, 0), 0, 0, 1, newSourceFile(source), diagnostics, nil}
+}
+
+func (s *scanner) ScanTokens() []*Token {
 	defer func() {
 		recover()
 	}()
@@ -43,15 +56,16 @@ func (s *Scanner) ScanTokens() []*Token {
 		s.start = s.current
 		s.scanToken()
 	}
-	s.Tokens = append(s.Tokens, newToken(EOF, "", nil, s.line))
+	s.start = s.current
+	s.addToken(EOF, nil)
 	return s.Tokens
 }
 
-func (s Scanner) isAtEnd() bool {
+func (s scanner) isAtEnd() bool {
 	return s.current >= len(s.source)
 }
 
-func (s *Scanner) scanToken() {
+func (s *scanner) scanToken() {
 	c := s.advance()
 	switch c {
 	case '(':
@@ -59,21 +73,63 @@ func (s *Scanner) scanToken() {
 	case ')':
 		s.addToken(RightParen, nil)
 	case '{':
+		if len(s.interpolations) > 0 {
+			s.interpolations[len(s.interpolations)-1]++
+		}
 		s.addToken(LeftBrace, nil)
 	case '}':
+		if len(s.interpolations) > 0 {
+			open := &s.interpolations[len(s.interpolations)-1]
+			if *open == 0 {
+				s.interpolations = s.interpolations[:len(s.interpolations)-1]
+				s.addToken(RightBrace, nil)
+				s.start = s.current
+				s.getString()
+				return
+			}
+			*open--
+		}
 		s.addToken(RightBrace, nil)
+	case '[':
+		s.addToken(LeftBracket, nil)
+	case ']':
+		s.addToken(RightBracket, nil)
 	case ',':
 		s.addToken(Comma, nil)
 	case '.':
 		s.addToken(Dot, nil)
 	case '-':
-		s.addToken(Minus, nil)
+		if s.match('-') {
+			s.addToken(MinusMinus, nil)
+		} else {
+			s.operator(Minus, MinusEqual)
+		}
 	case '+':
-		s.addToken(Plus, nil)
+		if s.match('+') {
+			s.addToken(PlusPlus, nil)
+		} else {
+			s.operator(Plus, PlusEqual)
+		}
 	case ';':
 		s.addToken(Semicolon, nil)
+	case ':':
+		s.addToken(Colon, nil)
+	case '?':
+		s.addToken(Question, nil)
 	case '*':
-		s.addToken(Star, nil)
+		if s.match('*') {
+			s.operator(StarStar, StarStarEqual)
+		} else {
+			s.operator(Star, StarEqual)
+		}
+	case '%':
+		s.operator(Percent, PercentEqual)
+	case '&':
+		s.operator(Ampersand, AmpersandEqual)
+	case '|':
+		s.operator(Pipe, PipeEqual)
+	case '^':
+		s.operator(Caret, CaretEqual)
 	case '!':
 		if s.match('=') {
 			s.addToken(BangEqual, nil)
@@ -83,17 +139,23 @@ func (s *Scanner) scanToken() {
 	case '=':
 		if s.match('=') {
 			s.addToken(EqualEqual, nil)
+		} else if s.match('>') {
+			s.addToken(Arrow, nil)
 		} else {
 			s.addToken(Equal, nil)
 		}
 	case '<':
-		if s.match('=') {
+		if s.match('<') {
+			s.operator(LessLess, LessLessEqual)
+		} else if s.match('=') {
 			s.addToken(LessEqual, nil)
 		} else {
 			s.addToken(Less, nil)
 		}
 	case '>':
-		if s.match('=') {
+		if s.match('>') {
+			s.operator(GreaterGreater, GreaterGreaterEqual)
+		} else if s.match('=') {
 			s.addToken(GreaterEqual, nil)
 		} else {
 			s.addToken(Greater, nil)
@@ -104,36 +166,49 @@ func (s *Scanner) scanToken() {
 				s.advance()
 			}
 		} else {
-			s.addToken(Slash, nil)
+			s.operator(Slash, SlashEqual)
 		}
 	case ' ', '\r', '\t':
 		//ignore
 	case '\n':
-		s.line++
+		s.newLine()
 	case '"':
 		s.getString()
+	case '`':
+		s.rawString()
 	default:
 		if s.isDigit(c) {
 			s.number()
 		} else if s.isAlpha(c) {
 			s.identifier()
 		} else {
-			emitError(s.line, "Unexpected character.")
+			s.error(CodeUnexpectedCharacter, "Unexpected character.")
 		}
 	}
 }
-func (s *Scanner) identifier() {
+
+// operator adds the token for a binary operator, or for its compound
+// assignment if an '=' follows.
+func (s *scanner) operator(binary TokenType, assign TokenType) {
+	if s.match('=') {
+		s.addToken(assign, nil)
+	} else {
+		s.addToken(binary, nil)
+	}
+}
+
+func (s *scanner) identifier() {
 	for s.isAlphaNumeric(s.peek()) {
 		s.advance()
 	}
-	text := string(s.source[s.start:s.current])
+	text := s.source[s.start:s.current]
 	t, ok := keywords[text]
 	if ok == false {
 		t = Identifier
 	}
 	s.addToken(t, nil)
 }
-func (s *Scanner) number() {
+func (s *scanner) number() {
 	for s.isDigit(s.peek()) {
 		s.advance()
 	}
@@ -143,69 +218,176 @@ func (s *Scanner) number() {
 			s.advance()
 		}
 	}
-	value, _ := strconv.ParseFloat(string(s.source[s.start:s.current]), 64)
+	value, _ := strconv.ParseFloat(s.source[s.start:s.current], 64)
 	s.addToken(Number, value)
 }
-func (s *Scanner) getString() {
+
+// getString scans a string literal, or the rest of one after the '}' that
+// ends an interpolated expression. The text up to a "${" becomes an Interpolation
+// token and the expression after it is scanned as usual, the text up to the
+// closing quote becomes a String.
+func (s *scanner) getString() {
+	var value strings.Builder
 	for s.peek() != '"' && !s.isAtEnd() {
-		if s.peek() == '\n' {
-			s.line++
+		c := s.advance()
+		switch {
+		case c == '\n':
+			s.newLine()
+			value.WriteRune(c)
+		case c == '\\':
+			s.escape(&value)
+		case c == '$' && s.peek() == '{':
+			s.advance()
+			s.interpolations = append(s.interpolations, 0)
+			s.addToken(Interpolation, value.String())
+			return
+		default:
+			value.WriteRune(c)
 		}
+	}
+	if s.isAtEnd() {
+		s.error(CodeUnterminatedString, "Unterminated string.")
+		return
+	}
+	s.advance()
+	s.addToken(String, value.String())
+}
+
+// escape decodes the escape sequence after a '\\' in a string.
+func (s *scanner) escape(value *strings.Builder) {
+	start := s.current - 1
+	if s.isAtEnd() || s.peek() == '\n' {
+		s.errorAt(start, CodeInvalidEscape, "Expect an escape sequence after '\\'.")
+		return
+	}
+	c := s.advance()
+	switch c {
+	case 'n':
+		value.WriteByte('\n')
+	case 't':
+		value.WriteByte('\t')
+	case 'r':
+		value.WriteByte('\r')
+	case '0':
+		value.WriteByte(0)
+	case '"', '\\', '$':
+		value.WriteRune(c)
+	case 'u':
+		s.unicodeEscape(value, start)
+	default:
+		s.errorAt(start, CodeInvalidEscape, "Invalid escape sequence '\\"+string(c)+"'.")
+	}
+}
+
+// unicodeEscape decodes the code point after "\\u", either four hex digits or
+// one to six between braces as in "\\u{1F600}".
+func (s *scanner) unicodeEscape(value *strings.Builder, start int) {
+	braced := s.match('{')
+	digits := s.current
+	for s.isHexDigit(s.peek()) && (braced || s.current-digits < 4) {
 		s.advance()
 	}
+	hex := s.source[digits:s.current]
+	valid := len(hex) == 4
+	if braced {
+		valid = len(hex) >= 1 && len(hex) <= 6 && s.match('}')
+	}
+	code, _ := strconv.ParseUint(hex, 16, 32)
+	if !valid || !utf8.ValidRune(rune(code)) {
+		s.errorAt(start, CodeInvalidEscape, "Invalid unicode escape sequence.")
+		return
+	}
+	value.WriteRune(rune(code))
+}
+
+// rawString scans a string between backticks. It may span lines like any
+// string, but has no escape sequences or interpolation.
+func (s *scanner) rawString() {
+	for s.peek() != '`' && !s.isAtEnd() {
+		s.advance()
+		if s.previous() == '\n' {
+			s.newLine()
+		}
+	}
 	if s.isAtEnd() {
-		emitError(s.line, "Unterminated string.")
+		s.error(CodeUnterminatedString, "Unterminated string.")
 		return
 	}
 	s.advance()
-	// Trim the quotes
-	value := string(s.source[s.start+1 : s.current-1])
-	s.addToken(String, value)
+	s.addToken(String, s.source[s.start+1:s.current-1])
 }
-func (s *Scanner) match(expected rune) bool {
+func (s *scanner) match(expected rune) bool {
 	if s.isAtEnd() {
 		return false
 	}
-	if s.source[s.current] != expected {
+	if s.peek() != expected {
 		return false
 	}
-	s.current++
+	s.advance()
 	return true
 }
-func (s Scanner) peek() rune {
+func (s scanner) peek() rune {
 	if s.isAtEnd() {
 		return '\000'
 	}
-	return s.source[s.current]
+	c, _ := utf8.DecodeRuneInString(s.source[s.current:])
+	return c
 }
-func (s Scanner) peekNext() rune {
-	if s.current+1 >= len(s.source) {
+func (s scanner) peekNext() rune {
+	if s.isAtEnd() {
 		return '\000'
 	}
-	return s.source[s.current+1]
+	_, size := utf8.DecodeRuneInString(s.source[s.current:])
+	if s.current+size >= len(s.source) {
+		return '\000'
+	}
+	c, _ := utf8.DecodeRuneInString(s.source[s.current+size:])
+	return c
+}
+func (s scanner) previous() rune {
+	c, _ := utf8.DecodeLastRuneInString(s.source[:s.current])
+	return c
 }
-func (s Scanner) isDigit(c rune) bool {
+func (s scanner) isDigit(c rune) bool {
 	switch c {
 	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 		return true
 	}
 	return false
 }
-func (s Scanner) isAlpha(c rune) bool {
+func (s scanner) isHexDigit(c rune) bool {
+	return s.isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func (s scanner) isAlpha(c rune) bool {
 	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
 }
 
-func (s Scanner) isAlphaNumeric(c rune) bool {
+func (s scanner) isAlphaNumeric(c rune) bool {
 	return s.isAlpha(c) || s.isDigit(c)
 }
 
-func (s *Scanner) advance() rune {
-	curr := rune(s.source[s.current])
-	s.current++
+func (s *scanner) advance() rune {
+	curr, size := utf8.DecodeRuneInString(s.source[s.current:])
+	s.current += size
 	return curr
 }
-func (s *Scanner) addToken(t TokenType, literal any) {
+func (s *scanner) addToken(t TokenType, literal any) {
 	text := s.source[s.start:s.current]
 	//fmt.Println(t, "(", literal, ")")
-	s.Tokens = append(s.Tokens, newToken(t, string(text), literal, s.line))
+	s.Tokens = append(s.Tokens, newToken(t, text, literal, s.line, s.start, s.current, s.file.column(s.start), s.file))
+}
+
+func (s *scanner) newLine() {
+	s.line++
+	s.file.addLine(s.current)
+}
+
+func (s *scanner) error(code Code, message string) {
+	s.errorAt(s.start, code, message)
+}
+
+// errorAt reports an error at the source from start to the current character.
+func (s *scanner) errorAt(start int, code Code, message string) {
+	s.diagnostics.Report(Diagnostic{SeverityError, code, message, s.file.span(start, s.current), nil, nil})
 }
@@ -0,0 +1,56 @@
+package lox
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// sourceFile is the text handed to Run along with where each line starts, so a
+// Span can be turned back into the line it came from.
+type sourceFile struct {
+	text  string
+	lines []int // byte offset of the start of each line, lines[0] is line 1
+}
+
+func newSourceFile(text string) *sourceFile {
+	return &sourceFile{text, []int{0}}
+}
+
+func (f *sourceFile) addLine(start int) {
+	f.lines = append(f.lines, start)
+}
+
+// lineAt returns the 1-based line containing offset.
+func (f *sourceFile) lineAt(offset int) int {
+	return sort.Search(len(f.lines), func(i int) bool {
+		return f.lines[i] > offset
+	})
+}
+
+// lineText returns line without its line ending. The scanner may not have
+// reached the end of the line yet, so it looks for the newline itself.
+func (f *sourceFile) lineText(line int) string {
+	text := f.text[f.lines[line-1]:]
+	end := strings.IndexByte(text, '\n')
+	if end >= 0 {
+		text = text[:end]
+	}
+	return strings.TrimSuffix(text, "\r")
+}
+
+func (f *sourceFile) column(offset int) int {
+	start := f.lines[f.lineAt(offset)-1]
+	return utf8.RuneCountInString(f.text[start:offset]) + 1
+}
+
+func (f *sourceFile) span(start int, end int) Span {
+	line := f.lineAt(start)
+	return Span{
+		Line:   line,
+		Column: f.column(start),
+		Start:  start,
+		End:    end,
+		Source: f.lineText(line),
+	}
+}
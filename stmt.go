@@ -1,108 +1,158 @@
-package main
+package lox
 
-import "slices"
+type stmtVisitor interface {
+	visitExprStmt(stmt *exprStmt) any
+	visitPrintStmt(stmt *printStmt) any
+	visitVariableStmt(stmt *variableStmt) any
+	visitBlockStmt(stmt *blockStmt) any
+	visitIfStmt(stmt *ifStmt) any
+	visitWhileStmt(stmt *whileStmt) any
+	visitFunctionStmt(stmt *functionStmt) any
+	visitReturnStmt(stmt *returnStmt) any
+	visitClassStmt(stmt *classStmt) any
+	visitBreakStmt(stmt *breakStmt) any
+	visitContinueStmt(stmt *continueStmt) any
+	visitThrowStmt(stmt *throwStmt) any
+	visitTryStmt(stmt *tryStmt) any
+}
+
+type stmt interface {
+	accept(visitor stmtVisitor) any
+}
 
-type StmtVisitor interface {
-	VisitExprStmt(stmt ExprStmt) any
-	VisitPrintStmt(stmt PrintStmt) any
-	VisitVariableStmt(stmt VariableStmt) any
-	VisitBlockStmt(stmt BlockStmt) any
-	VisitIfStmt(stmt IfStmt) any
-	VisitWhileStmt(stmt WhileStmt) any
-	VisitFunctionStmt(stmt FunctionStmt) any
-	VisitReturnStmt(stmt ReturnStmt) any
-	VisitClassStmt(stmt ClassStmt) any
+type breakStmt struct {
+	Keyword Token
+	// Label names the loop to leave, nil for the innermost one.
+	Label *Token
 }
 
-type Stmt interface {
-	Accept(visitor StmtVisitor) any
+type blockStmt struct {
+	Statements []stmt
+	// size is how many slots the block's environment needs.
+	size int
 }
 
-type BlockStmt struct {
-	Statements []Stmt
+type classStmt struct {
+	Name          Token
+	Superclass    *variableExpr
+	Methods       []*functionStmt
+	StaticMethods []*functionStmt
+	local         *binding
 }
 
-type ClassStmt struct {
-	Name       Token
-	Superclass *VariableExpr
-	Methods    []FunctionStmt
+type continueStmt struct {
+	Keyword Token
+	Label   *Token
 }
 
-type ExprStmt struct {
-	Expression Expr
+type exprStmt struct {
+	Expression expr
+	// Start is the first token of the statement.
+	Start Token
 }
 
-type FunctionStmt struct {
+type functionStmt struct {
 	Name   Token
 	Params []Token
-	Body   []Stmt
+	Body   []stmt
+	// local is the slot the function is declared in, nil for a global, and
+	// size is how many slots the environment of a call needs.
+	local *binding
+	size  int
 }
 
-func (f FunctionStmt) Equals(other FunctionStmt) bool {
-	if f.Name != other.Name {
-		return false
-	}
-	if !slices.Equal(f.Params, other.Params) {
-		return false
-	}
-	if !slices.Equal(f.Body, other.Body) {
-		return false
-	}
-	return true
+type ifStmt struct {
+	Keyword    Token
+	Condition  expr
+	ThenBranch stmt
+	ElseBranch stmt
 }
 
-type IfStmt struct {
-	Condition  Expr
-	ThenBranch Stmt
-	ElseBranch Stmt
+type printStmt struct {
+	Keyword    Token
+	Expression expr
 }
 
-type PrintStmt struct {
-	Expression Expr
+type returnStmt struct {
+	Keyword Token
+	Value   expr
 }
 
-type ReturnStmt struct {
+type throwStmt struct {
 	Keyword Token
-	Value   Expr
+	Value   expr
+}
+
+// tryStmt has a catch clause, a finally clause or both. Without a catch
+// CatchName and Catch are nil, without a finally Finally is.
+type tryStmt struct {
+	Keyword   Token
+	Body      *blockStmt
+	CatchName *Token
+	Catch     *blockStmt
+	Finally   *blockStmt
 }
 
-type VariableStmt struct {
+type variableStmt struct {
 	Name        Token
-	Initializer Expr
+	Initializer expr
+	local       *binding
 }
 
-type WhileStmt struct {
-	Condition Expr
-	Body      Stmt
+// whileStmt is also what a for loop becomes, Keyword is 'for' then.
+type whileStmt struct {
+	Keyword   Token
+	Condition expr
+	Body      stmt
+	// Increment is the last clause of a for loop, run after the body even
+	// when it continues.
+	Increment expr
+	Label     *Token
 }
 
-func (b ExprStmt) Accept(visitor StmtVisitor) any {
-	return visitor.VisitExprStmt(b)
+func (b *exprStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitExprStmt(b)
 }
-func (b PrintStmt) Accept(visitor StmtVisitor) any {
-	return visitor.VisitPrintStmt(b)
+func (b *printStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitPrintStmt(b)
 }
-func (b VariableStmt) Accept(visitor StmtVisitor) any {
-	return visitor.VisitVariableStmt(b)
+func (b *variableStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitVariableStmt(b)
 }
-func (b BlockStmt) Accept(visitor StmtVisitor) any {
-	return visitor.VisitBlockStmt(b)
+func (b *blockStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitBlockStmt(b)
 }
-func (b IfStmt) Accept(visitor StmtVisitor) any {
-	return visitor.VisitIfStmt(b)
+func (b *ifStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitIfStmt(b)
 }
 
-func (b WhileStmt) Accept(visitor StmtVisitor) any {
-	return visitor.VisitWhileStmt(b)
+func (b *whileStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitWhileStmt(b)
 }
 
-func (b FunctionStmt) Accept(visitor StmtVisitor) any {
-	return visitor.VisitFunctionStmt(b)
+func (b *functionStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitFunctionStmt(b)
 }
 
-func (b ReturnStmt) Accept(visitor StmtVisitor) any {
-	return visitor.VisitReturnStmt(b)
+func (b *returnStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitReturnStmt(b)
 }
-func (b ClassStmt) Accept(visitor StmtVisitor) any {
-	return visitor.VisitClassStmt(b)
+func (b *classStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitClassStmt(b)
+}
+
+func (b *breakStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitBreakStmt(b)
+}
+
+func (b *continueStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitContinueStmt(b)
+}
+
+func (b *throwStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitThrowStmt(b)
+}
+
+func (b *tryStmt) accept(visitor stmtVisitor) any {
+	return visitor.visitTryStmt(b)
 }
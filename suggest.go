@@ -0,0 +1,72 @@
+package lox
+
+import "iter"
+
+// suggest returns the candidate closest to name, or "" if none is close enough
+// to be a likely typo. Ties go to the candidate that sorts first so the
+// suggestion doesn't depend on map order.
+func suggest(name string, candidates iter.Seq[string]) string {
+	limit := (len([]rune(name)) + 1) / 3
+	best, bestDistance := "", limit+1
+	for candidate := range candidates {
+		if candidate == name {
+			continue
+		}
+		distance := editDistance(name, candidate)
+		if distance < bestDistance || distance == bestDistance && candidate < best {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// editDistance is the number of single rune insertions, deletions,
+// substitutions and swaps of adjacent runes it takes to turn a into b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// Only the last three rows of the table are needed.
+	previous2 := make([]int, len(t)+1)
+	previous := make([]int, len(t)+1)
+	current := make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				current[j] = min(current[j], previous2[j-2]+1)
+			}
+		}
+		previous2, previous, current = previous, current, previous2
+	}
+	return previous[len(t)]
+}
+
+// undefined is the message for a variable or property that doesn't exist,
+// with a suggestion when one of the candidates looks like what was meant.
+func undefined(what string, name string, candidates iter.Seq[string]) string {
+	message := "Undefined " + what + " '" + name + "'."
+	if suggestion := suggest(name, candidates); suggestion != "" {
+		message += " Did you mean '" + suggestion + "'?"
+	}
+	return message
+}
+
+// concat yields the names from each sequence in turn.
+func concat(sequences ...iter.Seq[string]) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for _, sequence := range sequences {
+			for name := range sequence {
+				if !yield(name) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package lox
 
 import (
 	"fmt"
@@ -16,6 +16,10 @@ func (t TokenType) String() string {
 		return "{"
 	case RightBrace:
 		return "}"
+	case LeftBracket:
+		return "["
+	case RightBracket:
+		return "]"
 	case Comma:
 		return ","
 	case Dot:
@@ -30,6 +34,18 @@ func (t TokenType) String() string {
 		return "/"
 	case Star:
 		return "*"
+	case Colon:
+		return ":"
+	case Question:
+		return "?"
+	case Percent:
+		return "%"
+	case Ampersand:
+		return "&"
+	case Pipe:
+		return "|"
+	case Caret:
+		return "^"
 
 		// One or two character tokens.
 	case Bang:
@@ -48,24 +64,68 @@ func (t TokenType) String() string {
 		return "<"
 	case LessEqual:
 		return "<="
+	case Arrow:
+		return "=>"
+	case StarStar:
+		return "**"
+	case LessLess:
+		return "<<"
+	case GreaterGreater:
+		return ">>"
+	case PlusPlus:
+		return "++"
+	case MinusMinus:
+		return "--"
+	case PlusEqual:
+		return "+="
+	case MinusEqual:
+		return "-="
+	case StarEqual:
+		return "*="
+	case SlashEqual:
+		return "/="
+	case PercentEqual:
+		return "%="
+	case StarStarEqual:
+		return "**="
+	case AmpersandEqual:
+		return "&="
+	case PipeEqual:
+		return "|="
+	case CaretEqual:
+		return "^="
+	case LessLessEqual:
+		return "<<="
+	case GreaterGreaterEqual:
+		return ">>="
 
 		// Literals.
 	case Identifier:
 		return "Identifier"
 	case String:
 		return "String"
+	case Interpolation:
+		return "Interpolation"
 	case Number:
 		return "Number"
 
 		// Keywords.
 	case And:
 		return "and"
+	case Break:
+		return "break"
+	case Catch:
+		return "catch"
 	case Class:
 		return "class"
+	case Continue:
+		return "continue"
 	case Else:
 		return "else"
 	case False:
 		return "false"
+	case Finally:
+		return "finally"
 	case Fun:
 		return "fun"
 	case For:
@@ -84,8 +144,12 @@ func (t TokenType) String() string {
 		return "super"
 	case This:
 		return "this"
+	case Throw:
+		return "throw"
 	case True:
 		return "true"
+	case Try:
+		return "try"
 	case Var:
 		return "var"
 	case While:
@@ -102,6 +166,8 @@ const (
 	RightParen
 	LeftBrace
 	RightBrace
+	LeftBracket
+	RightBracket
 	Comma
 	Dot
 	Minus
@@ -109,6 +175,12 @@ const (
 	Semicolon
 	Slash
 	Star
+	Colon
+	Question
+	Percent
+	Ampersand
+	Pipe
+	Caret
 
 	// One or two character tokens.
 	Bang
@@ -119,17 +191,43 @@ const (
 	GreaterEqual
 	Less
 	LessEqual
+	Arrow
+	StarStar
+	LessLess
+	GreaterGreater
+	PlusPlus
+	MinusMinus
+
+	// Compound assignment.
+	PlusEqual
+	MinusEqual
+	StarEqual
+	SlashEqual
+	PercentEqual
+	StarStarEqual
+	AmpersandEqual
+	PipeEqual
+	CaretEqual
+	LessLessEqual
+	GreaterGreaterEqual
 
 	// Literals.
 	Identifier
 	String
+	// Interpolation is the part of a string before a "${", the expression
+	// inside is scanned as tokens of its own.
+	Interpolation
 	Number
 
 	// Keywords.
 	And
+	Break
+	Catch
 	Class
+	Continue
 	Else
 	False
+	Finally
 	Fun
 	For
 	If
@@ -139,7 +237,9 @@ const (
 	Return
 	Super
 	This
+	Throw
 	True
+	Try
 	Var
 	While
 
@@ -151,10 +251,16 @@ type Token struct {
 	Lexeme  string
 	Literal any
 	Line    int
+	// Start and End are byte offsets into the source, Column is the 1-based
+	// rune count from the start of the line to Start.
+	Start  int
+	End    int
+	Column int
+	file   *sourceFile
 }
 
-func newToken(t TokenType, lexeme string, literal any, line int) *Token {
-	return &Token{t, lexeme, literal, line}
+func newToken(t TokenType, lexeme string, literal any, line int, start int, end int, column int, file *sourceFile) *Token {
+	return &Token{t, lexeme, literal, line, start, end, column, file}
 }
 func (t Token) String() string {
 	return fmt.Sprintf("%v %v %v", t.Type, t.Lexeme, t.Literal)
@@ -0,0 +1,226 @@
+package lox
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+)
+
+// Kind is the type of a Lox value.
+type Kind uint8
+
+const (
+	NilKind Kind = iota
+	BoolKind
+	NumberKind
+	StringKind
+	FunctionKind
+	NativeKind
+	ClassKind
+	InstanceKind
+	ListKind
+	MapKind
+	// errorKind only appears on the VM's stack, holding the *RuntimeError a
+	// handler caught until opCatch or opRethrow takes it.
+	errorKind
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NilKind:
+		return "nil"
+	case BoolKind:
+		return "bool"
+	case NumberKind:
+		return "number"
+	case StringKind:
+		return "string"
+	case FunctionKind:
+		return "function"
+	case NativeKind:
+		return "native"
+	case ClassKind:
+		return "class"
+	case InstanceKind:
+		return "instance"
+	case ListKind:
+		return "list"
+	case MapKind:
+		return "map"
+	case errorKind:
+		return "error"
+	}
+	panic("Unknown Kind")
+}
+
+// Value is a Lox value. The zero Value is nil. Functions, classes, instances,
+// lists and maps are held by pointer, so two Values are == exactly when Lox
+// considers them equal and a Value can be used as a key in a Go map.
+type Value struct {
+	kind    Kind
+	boolean bool
+	number  float64
+	str     string
+	object  any
+}
+
+func BoolValue(b bool) Value {
+	return Value{kind: BoolKind, boolean: b}
+}
+
+func NumberValue(n float64) Value {
+	return Value{kind: NumberKind, number: n}
+}
+
+func StringValue(s string) Value {
+	return Value{kind: StringKind, str: s}
+}
+
+// objectValue wraps a pointer to one of the interpreter's or the VM's
+// runtime objects.
+func objectValue(kind Kind, object any) Value {
+	return Value{kind: kind, object: object}
+}
+
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+func (v Value) IsNil() bool {
+	return v.kind == NilKind
+}
+
+// AsBool returns the value of a bool, and false for any other kind.
+func (v Value) AsBool() bool {
+	return v.boolean
+}
+
+// AsNumber returns the value of a number, and 0 for any other kind.
+func (v Value) AsNumber() float64 {
+	return v.number
+}
+
+// AsString returns the value of a string, and "" for any other kind.
+func (v Value) AsString() string {
+	return v.str
+}
+
+// Field returns the field called name of an instance. ok is false if v isn't
+// an instance or has no such field, methods aren't fields.
+func (v Value) Field(name string) (value Value, ok bool) {
+	value, ok = v.fields()[name]
+	return value, ok
+}
+
+// SetField sets the field called name of an instance, and reports false
+// without doing anything if v isn't one.
+func (v Value) SetField(name string, value Value) bool {
+	fields := v.fields()
+	if fields == nil {
+		return false
+	}
+	fields[name] = value
+	return true
+}
+
+// ClassName returns the name of a class, or of the class of an instance, and
+// "" for any other kind.
+func (v Value) ClassName() string {
+	switch object := v.object.(type) {
+	case *loxClass:
+		return object.Name
+	case *vmClass:
+		return object.name
+	case *loxInstance:
+		return object.Class.Name
+	case *vmInstance:
+		return object.class.name
+	}
+	return ""
+}
+
+// fields returns the fields of an instance on either backend, nil for any
+// other kind.
+func (v Value) fields() map[string]Value {
+	switch object := v.object.(type) {
+	case *loxInstance:
+		return object.fields
+	case *vmInstance:
+		return object.fields
+	}
+	return nil
+}
+
+// IsTruthy follows Ruby's rule: nil and false are falsey, everything else is
+// truthy.
+func (v Value) IsTruthy() bool {
+	switch v.kind {
+	case NilKind:
+		return false
+	case BoolKind:
+		return v.boolean
+	}
+	return true
+}
+
+// Equal is Lox's == operator.
+func (v Value) Equal(other Value) bool {
+	return v == other
+}
+
+var valueSeed = maphash.MakeSeed()
+
+// Hash returns a hash of v that agrees with Equal for the life of the process.
+func (v Value) Hash() uint64 {
+	return maphash.Comparable(valueSeed, v)
+}
+
+// String formats v the way print does.
+func (v Value) String() string {
+	switch v.kind {
+	case NilKind:
+		return "nil"
+	case BoolKind:
+		return fmt.Sprint(v.boolean)
+	case NumberKind:
+		return fmt.Sprint(v.number)
+	case StringKind:
+		return v.str
+	}
+	return fmt.Sprint(v.object)
+}
+
+// goValue is v as the Go value natives taking an interface receive: nil, a
+// bool, a float64, a string, a list or map, or v itself for objects each
+// backend represents its own way.
+func (v Value) goValue() any {
+	switch v.kind {
+	case NilKind:
+		return nil
+	case BoolKind:
+		return v.boolean
+	case NumberKind:
+		return v.number
+	case StringKind:
+		return v.str
+	case ListKind, MapKind:
+		return v.object
+	}
+	return v
+}
+
+// integerOperands returns the operands of a bitwise operator as integers. They
+// must be numbers with integer values that fit in 64 bits, and a shift count
+// can't be negative.
+func integerOperands(operator Token, left Value, right Value) (int64, int64, error) {
+	for _, operand := range []Value{left, right} {
+		if operand.kind != NumberKind || operand.number != math.Trunc(operand.number) ||
+			operand.number < math.MinInt64 || operand.number >= math.MaxInt64 {
+			return 0, 0, RuntimeError{operator, "Operands must be integers.", nil, Value{}}
+		}
+	}
+	if (operator.Type == LessLess || operator.Type == GreaterGreater) && right.number < 0 {
+		return 0, 0, RuntimeError{operator, "Shift count can't be negative.", nil, Value{}}
+	}
+	return int64(left.number), int64(right.number), nil
+}
@@ -0,0 +1,919 @@
+package lox
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"maps"
+	"math"
+	"slices"
+)
+
+// vmFunction is a compiled function. Closures share it and add the captured
+// upvalues.
+type vmFunction struct {
+	name         string
+	class        string
+	arity        int
+	upvalueCount int
+	chunk        chunk
+}
+
+func (f *vmFunction) String() string {
+	if f.name == "" {
+		return "<script>"
+	}
+	return "<fn " + f.name + ">"
+}
+
+type vmClosure struct {
+	function *vmFunction
+	upvalues []*vmUpvalue
+}
+
+func (c *vmClosure) String() string {
+	return c.function.String()
+}
+
+// vmUpvalue is a captured variable. While open it refers to a slot on the VM
+// stack by index, since the stack may be reallocated as it grows. Closing it
+// moves the value into the upvalue itself.
+type vmUpvalue struct {
+	slot   int
+	closed Value
+	open   bool
+	next   *vmUpvalue
+}
+
+type vmClass struct {
+	name       string
+	superclass *vmClass
+	methods    map[string]*vmClosure
+	statics    map[string]*vmClosure
+	// fields are the class's static fields. Unlike methods, statics aren't
+	// copied into subclasses since fields can be added at any time, lookup
+	// goes through the superclasses instead.
+	fields map[string]Value
+}
+
+// lookup finds a static field or method on the class or the classes it
+// inherits from. At each class its fields come before its methods.
+func (c *vmClass) lookup(name string) (Value, *vmClosure, bool) {
+	for class := c; class != nil; class = class.superclass {
+		value, ok := class.fields[name]
+		if ok {
+			return value, nil, true
+		}
+		method, ok := class.statics[name]
+		if ok {
+			return Value{}, method, true
+		}
+	}
+	return Value{}, nil, false
+}
+
+// findStatic finds a static method on the class or the classes it inherits
+// from, for super in a static method.
+func (c *vmClass) findStatic(name string) (*vmClosure, bool) {
+	for class := c; class != nil; class = class.superclass {
+		method, ok := class.statics[name]
+		if ok {
+			return method, true
+		}
+	}
+	return nil, false
+}
+
+// staticNames yields the names lookup can find, and methodsOnly those
+// findStatic can.
+func (c *vmClass) staticNames(methodsOnly bool) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for class := c; class != nil; class = class.superclass {
+			names := maps.Keys(class.statics)
+			if !methodsOnly {
+				names = concat(maps.Keys(class.fields), names)
+			}
+			for name := range names {
+				if !yield(name) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *vmClass) isSubclassOf(other *vmClass) bool {
+	for class := c; class != nil; class = class.superclass {
+		if class == other {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *vmClass) String() string {
+	return c.name
+}
+
+type vmInstance struct {
+	class  *vmClass
+	fields map[string]Value
+}
+
+func (i *vmInstance) String() string {
+	return i.class.name + " instance"
+}
+
+type vmBoundMethod struct {
+	receiver Value
+	method   *vmClosure
+}
+
+func (b *vmBoundMethod) String() string {
+	return b.method.String()
+}
+
+type callFrame struct {
+	closure *vmClosure
+	ip      int
+	base    int
+	// constructor is set when the frame runs init for a class call, the
+	// trace names it after the class like the tree-walker does.
+	constructor bool
+}
+
+// vmHandler is a try statement being executed. A RuntimeError raised inside
+// it unwinds to frame and stack height stack and resumes at ip.
+type vmHandler struct {
+	frame int
+	stack int
+	ip    int
+}
+
+// nativeCall is a built-in method the VM is running, which may be calling back
+// into Lox. depth is how many frames there were when it was called, so a
+// trace can show it between the frames it called and the one that called it.
+type nativeCall struct {
+	depth int
+	frame TraceFrame
+}
+
+// machine executes bytecode produced by the Compiler. Globals live in the same
+// map as the tree-walker's so natives from DefineFunc and definitions from
+// earlier runs are visible to it.
+type machine struct {
+	stack        []Value
+	frames       []callFrame
+	handlers     []vmHandler
+	natives      []nativeCall
+	globals      map[string]Value
+	openUpvalues *vmUpvalue
+	// interpreter holds the output and limits, which can change after the
+	// VM is created.
+	interpreter *Interpreter
+	ctx         context.Context
+	// steps counts what the tree-walker would, see chunk. instructions
+	// counts instructions so the context is only checked every so often.
+	steps        int
+	instructions int
+	errorClass   *vmClass
+}
+
+func newVM(i *Interpreter) *machine {
+	return &machine{
+		globals:     i.globals.values,
+		interpreter: i,
+	}
+}
+
+// interpret runs the compiled script. A runtime error leaves the stack in an
+// unknown state, so it is reset before returning.
+func (vm *machine) interpret(ctx context.Context, function *vmFunction) (err error) {
+	vm.ctx = ctx
+	vm.steps = 0
+	defer func() {
+		vm.stack = vm.stack[:0]
+		vm.frames = vm.frames[:0]
+		vm.handlers = vm.handlers[:0]
+		vm.natives = vm.natives[:0]
+		vm.openUpvalues = nil
+	}()
+	closure := &vmClosure{function, nil}
+	vm.push(objectValue(FunctionKind, closure))
+	err = vm.call(closure, 0, Token{})
+	if err == nil {
+		err = vm.execute(0)
+	}
+	runtimeError, ok := err.(RuntimeError)
+	if ok && runtimeError.Trace == nil {
+		runtimeError.Trace = vm.trace(runtimeError.Token)
+		return runtimeError
+	}
+	return err
+}
+
+// execute runs until the frame at depth base returns. A RuntimeError raised in
+// a try statement within those frames is caught and execution carries on.
+func (vm *machine) execute(base int) error {
+	err := vm.run(base)
+	for {
+		runtimeError, ok := err.(RuntimeError)
+		if !ok || len(vm.handlers) == 0 || vm.handlers[len(vm.handlers)-1].frame < base {
+			return err
+		}
+		vm.catch(runtimeError)
+		err = vm.run(base)
+	}
+}
+
+// trace builds a TraceFrame for each frame on the call stack, innermost
+// first. at is where the innermost frame failed, the others are at the call
+// they are waiting on.
+func (vm *machine) trace(at Token) []TraceFrame {
+	trace := make([]TraceFrame, 0, len(vm.frames))
+	line := at.Line
+	natives := len(vm.natives)
+	for k := len(vm.frames) - 1; k >= 0; k-- {
+		for natives > 0 && vm.natives[natives-1].depth > k {
+			natives--
+			trace = append(trace, vm.natives[natives].frame)
+		}
+		frame := vm.frames[k]
+		function := frame.closure.function
+		if k < len(vm.frames)-1 {
+			line = function.chunk.Tokens[frame.ip-1].Line
+		}
+		if frame.constructor {
+			trace = append(trace, TraceFrame{function.class, "", line, false})
+			continue
+		}
+		trace = append(trace, TraceFrame{function.name, function.class, line, false})
+	}
+	return trace
+}
+
+// catch unwinds to the innermost handler and resumes there, with err on top
+// of the stack for opCatch or opRethrow to take.
+func (vm *machine) catch(err RuntimeError) {
+	if err.Trace == nil {
+		err.Trace = vm.trace(err.Token)
+	}
+	handler := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.closeUpvalues(handler.stack)
+	vm.frames = vm.frames[:handler.frame+1]
+	vm.stack = vm.stack[:handler.stack]
+	vm.frames[handler.frame].ip = handler.ip
+	vm.push(objectValue(errorKind, &err))
+}
+
+// exception is the value a catch clause receives for err: whatever was
+// thrown, or an Error describing a runtime error.
+func (vm *machine) exception(err RuntimeError) Value {
+	if !err.Value.IsNil() {
+		return err.Value
+	}
+	return objectValue(InstanceKind, &vmInstance{vm.errorClass, err.errorFields()})
+}
+
+func (vm *machine) push(value Value) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *machine) pop() Value {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+func (vm *machine) peek(distance int) Value {
+	return vm.stack[len(vm.stack)-1-distance]
+}
+
+// run executes instructions until the frame at depth base returns, leaving its
+// result on the stack.
+func (vm *machine) run(base int) error {
+	frame := &vm.frames[len(vm.frames)-1]
+	chunk := &frame.closure.function.chunk
+
+	readByte := func() byte {
+		b := chunk.Code[frame.ip]
+		frame.ip++
+		return b
+	}
+	readShort := func() int {
+		frame.ip += 2
+		return chunk.readShort(frame.ip - 2)
+	}
+	readString := func() string {
+		return chunk.Constants[readShort()].str
+	}
+	maxSteps := vm.interpreter.maxSteps
+
+	for {
+		vm.steps += chunk.Steps[frame.ip]
+		if maxSteps > 0 && vm.steps > maxSteps {
+			return &InterruptError{ErrStepBudgetExhausted}
+		}
+		vm.instructions++
+		if vm.instructions&1023 == 0 {
+			select {
+			case <-vm.ctx.Done():
+				return &InterruptError{vm.ctx.Err()}
+			default:
+			}
+		}
+
+		start := frame.ip
+		switch opCode(readByte()) {
+		case opConstant:
+			vm.push(chunk.Constants[readShort()])
+		case opNil:
+			vm.push(Value{})
+		case opTrue:
+			vm.push(BoolValue(true))
+		case opFalse:
+			vm.push(BoolValue(false))
+		case opNop:
+		case opPop:
+			vm.pop()
+		case opDup:
+			vm.push(vm.peek(0))
+		case opDup2:
+			vm.push(vm.peek(1))
+			vm.push(vm.peek(1))
+		case opRotate:
+			// Move the top value under the n below it.
+			n := int(readByte())
+			value := vm.pop()
+			vm.stack = slices.Insert(vm.stack, len(vm.stack)-n, value)
+		case opGetLocal:
+			vm.push(vm.stack[frame.base+readShort()])
+		case opSetLocal:
+			vm.stack[frame.base+readShort()] = vm.peek(0)
+		case opGetGlobal:
+			name := readString()
+			value, ok := vm.globals[name]
+			if !ok {
+				return RuntimeError{chunk.Tokens[start], undefined("variable", name, maps.Keys(vm.globals)), nil, Value{}}
+			}
+			vm.push(value)
+		case opDefineGlobal:
+			vm.globals[readString()] = vm.pop()
+		case opSetGlobal:
+			name := readString()
+			_, ok := vm.globals[name]
+			if !ok {
+				return RuntimeError{chunk.Tokens[start], undefined("variable", name, maps.Keys(vm.globals)), nil, Value{}}
+			}
+			vm.globals[name] = vm.peek(0)
+		case opGetUpvalue:
+			upvalue := frame.closure.upvalues[readShort()]
+			if upvalue.open {
+				vm.push(vm.stack[upvalue.slot])
+			} else {
+				vm.push(upvalue.closed)
+			}
+		case opSetUpvalue:
+			upvalue := frame.closure.upvalues[readShort()]
+			if upvalue.open {
+				vm.stack[upvalue.slot] = vm.peek(0)
+			} else {
+				upvalue.closed = vm.peek(0)
+			}
+		case opGetProperty:
+			builtin, ok := vm.peek(0).object.(builtinType)
+			if ok {
+				method, err := builtin.method(readString(), chunk.Tokens[start])
+				if err != nil {
+					return err
+				}
+				vm.pop()
+				vm.push(objectValue(NativeKind, method))
+				break
+			}
+			class, ok := vm.peek(0).object.(*vmClass)
+			if ok {
+				err := vm.getStatic(class, readString(), chunk.Tokens[start])
+				if err != nil {
+					return err
+				}
+				break
+			}
+			instance, ok := vm.peek(0).object.(*vmInstance)
+			if !ok {
+				return RuntimeError{chunk.Tokens[start], "Only instances have properties.", nil, Value{}}
+			}
+			name := readString()
+			value, ok := instance.fields[name]
+			if ok {
+				vm.pop()
+				vm.push(value)
+				break
+			}
+			err := vm.bindMethod(instance.class, instance.fields, name, chunk.Tokens[start])
+			if err != nil {
+				return err
+			}
+		case opSetProperty:
+			var fields map[string]Value
+			switch object := vm.peek(1).object.(type) {
+			case *vmInstance:
+				fields = object.fields
+			case *vmClass:
+				fields = object.fields
+			default:
+				return RuntimeError{chunk.Tokens[start], "Only instances have fields.", nil, Value{}}
+			}
+			value := vm.pop()
+			fields[readString()] = value
+			vm.pop()
+			vm.push(value)
+		case opGetSuper:
+			superclass := vm.pop().object.(*vmClass)
+			name := readString()
+			if vm.peek(0).kind == ClassKind {
+				method, ok := superclass.findStatic(name)
+				if !ok {
+					return RuntimeError{chunk.Tokens[start], undefined("property", name, superclass.staticNames(true)), nil, Value{}}
+				}
+				bound := &vmBoundMethod{vm.pop(), method}
+				vm.push(objectValue(FunctionKind, bound))
+				break
+			}
+			err := vm.bindMethod(superclass, nil, name, chunk.Tokens[start])
+			if err != nil {
+				return err
+			}
+		case opEqual:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(BoolValue(a.Equal(b)))
+		case opGreater, opGreaterEqual, opLess, opLessEqual, opSubtract, opMultiply, opDivide, opModulo, opPower:
+			op := opCode(chunk.Code[start])
+			right := vm.peek(0)
+			left := vm.peek(1)
+			if left.kind != NumberKind || right.kind != NumberKind {
+				return RuntimeError{chunk.Tokens[start], "Operands must be numbers.", nil, Value{}}
+			}
+			vm.pop()
+			vm.pop()
+			switch op {
+			case opGreater:
+				vm.push(BoolValue(left.number > right.number))
+			case opGreaterEqual:
+				vm.push(BoolValue(left.number >= right.number))
+			case opLess:
+				vm.push(BoolValue(left.number < right.number))
+			case opLessEqual:
+				vm.push(BoolValue(left.number <= right.number))
+			case opSubtract:
+				vm.push(NumberValue(left.number - right.number))
+			case opMultiply:
+				vm.push(NumberValue(left.number * right.number))
+			case opDivide:
+				if right.number == 0 {
+					return RuntimeError{chunk.Tokens[start], "division by zero! panic", nil, Value{}}
+				}
+				vm.push(NumberValue(left.number / right.number))
+			case opModulo:
+				if right.number == 0 {
+					return RuntimeError{chunk.Tokens[start], "division by zero! panic", nil, Value{}}
+				}
+				vm.push(NumberValue(math.Mod(left.number, right.number)))
+			case opPower:
+				vm.push(NumberValue(math.Pow(left.number, right.number)))
+			}
+		case opBitAnd, opBitOr, opBitXor, opShiftLeft, opShiftRight:
+			op := opCode(chunk.Code[start])
+			a, b, err := integerOperands(chunk.Tokens[start], vm.peek(1), vm.peek(0))
+			if err != nil {
+				return err
+			}
+			vm.pop()
+			vm.pop()
+			switch op {
+			case opBitAnd:
+				vm.push(NumberValue(float64(a & b)))
+			case opBitOr:
+				vm.push(NumberValue(float64(a | b)))
+			case opBitXor:
+				vm.push(NumberValue(float64(a ^ b)))
+			case opShiftLeft:
+				vm.push(NumberValue(float64(a << b)))
+			case opShiftRight:
+				vm.push(NumberValue(float64(a >> b)))
+			}
+		case opAdd:
+			right := vm.peek(0)
+			left := vm.peek(1)
+			if left.kind == NumberKind && right.kind == NumberKind {
+				vm.pop()
+				vm.pop()
+				vm.push(NumberValue(left.number + right.number))
+				continue
+			}
+			if left.kind == StringKind && right.kind == StringKind {
+				vm.pop()
+				vm.pop()
+				vm.push(StringValue(left.str + right.str))
+				continue
+			}
+			return RuntimeError{chunk.Tokens[start], "Operands must be two numbers or two strings.", nil, Value{}}
+		case opNot:
+			vm.push(BoolValue(!vm.pop().IsTruthy()))
+		case opNegate:
+			value := vm.peek(0)
+			if value.kind != NumberKind {
+				return RuntimeError{chunk.Tokens[start], "Operand must be a number.", nil, Value{}}
+			}
+			vm.pop()
+			vm.push(NumberValue(-value.number))
+		case opStringify:
+			if vm.peek(0).kind != StringKind {
+				vm.push(StringValue(vm.pop().String()))
+			}
+		case opPrint:
+			fmt.Fprintln(vm.interpreter.stdout, vm.pop())
+		case opJump:
+			offset := readShort()
+			frame.ip += offset
+		case opJumpIfFalse:
+			offset := readShort()
+			if !vm.peek(0).IsTruthy() {
+				frame.ip += offset
+			}
+		case opLoop:
+			offset := readShort()
+			frame.ip -= offset
+		case opCall:
+			argCount := int(readByte())
+			err := vm.callValue(vm.peek(argCount), argCount, chunk.Tokens[start+1])
+			if err != nil {
+				return err
+			}
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.function.chunk
+		case opInvoke:
+			method := readString()
+			argCount := int(readByte())
+			err := vm.invoke(method, argCount, chunk.Tokens[start], chunk.Tokens[start+3])
+			if err != nil {
+				return err
+			}
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.function.chunk
+		case opSuperInvoke:
+			method := readString()
+			argCount := int(readByte())
+			superclass := vm.pop().object.(*vmClass)
+			var err error
+			if vm.peek(argCount).kind == ClassKind {
+				static, ok := superclass.findStatic(method)
+				if !ok {
+					return RuntimeError{chunk.Tokens[start], undefined("property", method, superclass.staticNames(true)), nil, Value{}}
+				}
+				err = vm.call(static, argCount, chunk.Tokens[start+3])
+			} else {
+				err = vm.invokeFromClass(superclass, nil, method, argCount, chunk.Tokens[start], chunk.Tokens[start+3])
+			}
+			if err != nil {
+				return err
+			}
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.function.chunk
+		case opClosure:
+			function := chunk.Constants[readShort()].object.(*vmFunction)
+			closure := &vmClosure{function, make([]*vmUpvalue, function.upvalueCount)}
+			for i := range closure.upvalues {
+				isLocal := readByte()
+				index := readShort()
+				if isLocal == 1 {
+					closure.upvalues[i] = vm.captureUpvalue(frame.base + index)
+				} else {
+					closure.upvalues[i] = frame.closure.upvalues[index]
+				}
+			}
+			vm.push(objectValue(FunctionKind, closure))
+		case opCloseUpvalue:
+			vm.closeUpvalues(len(vm.stack) - 1)
+			vm.pop()
+		case opReturn:
+			result := vm.pop()
+			vm.closeUpvalues(frame.base)
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.stack = vm.stack[:frame.base]
+			vm.push(result)
+			if len(vm.frames) == base {
+				return nil
+			}
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.function.chunk
+		case opClass:
+			class := &vmClass{readString(), nil, make(map[string]*vmClosure), make(map[string]*vmClosure), make(map[string]Value)}
+			vm.push(objectValue(ClassKind, class))
+		case opInherit:
+			superclass, ok := vm.peek(1).object.(*vmClass)
+			if !ok {
+				return RuntimeError{chunk.Tokens[start], "Superclass must be a class.", nil, Value{}}
+			}
+			subclass := vm.peek(0).object.(*vmClass)
+			subclass.superclass = superclass
+			for name, method := range superclass.methods {
+				subclass.methods[name] = method
+			}
+			vm.pop()
+		case opMethod:
+			method := vm.peek(0).object.(*vmClosure)
+			class := vm.peek(1).object.(*vmClass)
+			class.methods[readString()] = method
+			vm.pop()
+		case opStaticMethod:
+			method := vm.peek(0).object.(*vmClosure)
+			class := vm.peek(1).object.(*vmClass)
+			class.statics[readString()] = method
+			vm.pop()
+		case opTry:
+			offset := readShort()
+			vm.handlers = append(vm.handlers, vmHandler{len(vm.frames) - 1, len(vm.stack), frame.ip + offset})
+		case opEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case opCatch:
+			err := vm.pop().object.(*RuntimeError)
+			vm.push(vm.exception(*err))
+		case opThrow:
+			value := vm.pop()
+			token := chunk.Tokens[start]
+			if value.IsNil() {
+				return RuntimeError{token, "Can't throw nil.", nil, Value{}}
+			}
+			var fields map[string]Value
+			isError := false
+			instance, ok := value.object.(*vmInstance)
+			if ok {
+				fields = instance.fields
+				isError = instance.class.isSubclassOf(vm.errorClass)
+			}
+			return newThrow(token, value, vm.trace(token), fields, isError)
+		case opRethrow:
+			return *vm.pop().object.(*RuntimeError)
+		case opBuildList:
+			count := readShort()
+			elements := slices.Clone(vm.stack[len(vm.stack)-count:])
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(objectValue(ListKind, newLoxList(elements)))
+		case opBuildMap:
+			count := readShort()
+			entries := vm.stack[len(vm.stack)-2*count:]
+			m := newLoxMap()
+			for k := 0; k < len(entries); k += 2 {
+				err := m.set(entries[k], entries[k+1], chunk.Tokens[start])
+				if err != nil {
+					return err
+				}
+			}
+			vm.stack = vm.stack[:len(vm.stack)-2*count]
+			vm.push(objectValue(MapKind, m))
+		case opGetIndex:
+			value, err := getIndex(vm.peek(1), vm.peek(0), chunk.Tokens[start])
+			if err != nil {
+				return err
+			}
+			vm.stack = vm.stack[:len(vm.stack)-2]
+			vm.push(value)
+		case opSetIndex:
+			value := vm.peek(0)
+			err := setIndex(vm.peek(2), vm.peek(1), value, chunk.Tokens[start])
+			if err != nil {
+				return err
+			}
+			vm.stack = vm.stack[:len(vm.stack)-3]
+			vm.push(value)
+		}
+	}
+}
+
+// callValue calls the callee sitting below its arguments on the stack. paren
+// is where errors about the call itself are reported.
+func (vm *machine) callValue(callee Value, argCount int, paren Token) error {
+	switch callee := callee.object.(type) {
+	case *vmBoundMethod:
+		vm.stack[len(vm.stack)-argCount-1] = callee.receiver
+		return vm.call(callee.method, argCount, paren)
+	case *vmClass:
+		vm.stack[len(vm.stack)-argCount-1] = objectValue(InstanceKind, &vmInstance{callee, make(map[string]Value)})
+		initializer, ok := callee.methods["init"]
+		if ok {
+			err := vm.call(initializer, argCount, paren)
+			if err == nil {
+				vm.frames[len(vm.frames)-1].constructor = true
+			}
+			return err
+		}
+		if argCount != 0 {
+			return RuntimeError{paren, fmt.Sprintf("Expected 0 arguments but got %d.", argCount), nil, Value{}}
+		}
+		return vm.checkDepth(paren)
+	case *vmClosure:
+		return vm.call(callee, argCount, paren)
+	case *builtinMethod:
+		return vm.callBuiltin(callee, argCount, paren)
+	case *nativeFunction:
+		if argCount != callee.Arity() {
+			return RuntimeError{paren, fmt.Sprintf("Expected %d arguments but got %d.", callee.Arity(), argCount), nil, Value{}}
+		}
+		err := vm.checkDepth(paren)
+		if err != nil {
+			return err
+		}
+		arguments := make([]Value, argCount)
+		copy(arguments, vm.stack[len(vm.stack)-argCount:])
+		result, err := callee.call(arguments)
+		if err != nil {
+			native := TraceFrame{callee.name, "", 0, true}
+			runtimeError := nativeError(paren, err)
+			runtimeError.Trace = append([]TraceFrame{native}, vm.trace(paren)...)
+			return runtimeError
+		}
+		vm.stack = vm.stack[:len(vm.stack)-argCount-1]
+		vm.push(result)
+		return nil
+	}
+	return RuntimeError{paren, "Can only call functions and classes.", nil, Value{}}
+}
+
+// callBuiltin calls a method of a built-in type. Functions it calls back are
+// run to completion by callFunction before it returns.
+func (vm *machine) callBuiltin(method *builtinMethod, argCount int, paren Token) error {
+	err := method.checkArity(argCount, paren)
+	if err == nil {
+		err = vm.checkDepth(paren)
+	}
+	if err != nil {
+		return err
+	}
+	arguments := slices.Clone(vm.stack[len(vm.stack)-argCount:])
+	vm.natives = append(vm.natives, nativeCall{len(vm.frames), TraceFrame{method.name, method.class, 0, true}})
+	callback := func(callee Value, arguments []Value) (Value, error) {
+		return vm.callFunction(callee, arguments, paren)
+	}
+	result, err := method.fn(builtinCall{arguments, paren, callback})
+	runtimeError, ok := err.(RuntimeError)
+	if ok && runtimeError.Trace == nil {
+		// The trace has to be taken while this call is still on it.
+		runtimeError.Trace = vm.trace(runtimeError.Token)
+		err = runtimeError
+	}
+	vm.natives = vm.natives[:len(vm.natives)-1]
+	if err != nil {
+		return err
+	}
+	vm.stack = vm.stack[:len(vm.stack)-argCount-1]
+	vm.push(result)
+	return nil
+}
+
+// callFunction calls callee from Go and runs it to completion, for built-in
+// methods that call back into Lox.
+func (vm *machine) callFunction(callee Value, arguments []Value, paren Token) (Value, error) {
+	depth := len(vm.frames)
+	vm.push(callee)
+	for _, argument := range arguments {
+		vm.push(argument)
+	}
+	err := vm.callValue(callee, len(arguments), paren)
+	if err == nil && len(vm.frames) > depth {
+		err = vm.execute(depth)
+	}
+	if err != nil {
+		return Value{}, err
+	}
+	return vm.pop(), nil
+}
+
+func (vm *machine) call(closure *vmClosure, argCount int, paren Token) error {
+	if argCount != closure.function.arity {
+		return RuntimeError{paren, fmt.Sprintf("Expected %d arguments but got %d.", closure.function.arity, argCount), nil, Value{}}
+	}
+	err := vm.checkDepth(paren)
+	if err != nil {
+		return err
+	}
+	vm.frames = append(vm.frames, callFrame{closure, 0, len(vm.stack) - argCount - 1, false})
+	return nil
+}
+
+// checkDepth fails the same way the tree-walker does once calls nest deeper
+// than the limit. The script's own frame doesn't count as a call.
+func (vm *machine) checkDepth(paren Token) error {
+	if len(vm.frames) > vm.interpreter.maxCallDepth {
+		return RuntimeError{paren, "Stack overflow.", nil, Value{}}
+	}
+	return nil
+}
+
+func (vm *machine) invoke(name string, argCount int, nameToken Token, paren Token) error {
+	receiver := vm.peek(argCount)
+	builtin, ok := receiver.object.(builtinType)
+	if ok {
+		method, err := builtin.method(name, nameToken)
+		if err != nil {
+			return err
+		}
+		return vm.callBuiltin(method, argCount, paren)
+	}
+	class, ok := receiver.object.(*vmClass)
+	if ok {
+		value, method, ok := class.lookup(name)
+		if !ok {
+			return RuntimeError{nameToken, undefined("property", name, class.staticNames(false)), nil, Value{}}
+		}
+		if method != nil {
+			return vm.call(method, argCount, paren)
+		}
+		vm.stack[len(vm.stack)-argCount-1] = value
+		return vm.callValue(value, argCount, paren)
+	}
+	instance, ok := receiver.object.(*vmInstance)
+	if !ok {
+		return RuntimeError{nameToken, "Only instances have properties.", nil, Value{}}
+	}
+	value, ok := instance.fields[name]
+	if ok {
+		vm.stack[len(vm.stack)-argCount-1] = value
+		return vm.callValue(value, argCount, paren)
+	}
+	return vm.invokeFromClass(instance.class, instance.fields, name, argCount, nameToken, paren)
+}
+
+func (vm *machine) invokeFromClass(class *vmClass, fields map[string]Value, name string, argCount int, nameToken Token, paren Token) error {
+	method, ok := class.methods[name]
+	if !ok {
+		return vm.undefinedProperty(class, fields, name, nameToken)
+	}
+	return vm.call(method, argCount, paren)
+}
+
+// bindMethod replaces the instance on top of the stack with its method name
+// bound to it. fields are the instance's own, only used to suggest a name
+// when there is no such method.
+func (vm *machine) bindMethod(class *vmClass, fields map[string]Value, name string, token Token) error {
+	method, ok := class.methods[name]
+	if !ok {
+		return vm.undefinedProperty(class, fields, name, token)
+	}
+	bound := &vmBoundMethod{vm.peek(0), method}
+	vm.pop()
+	vm.push(objectValue(FunctionKind, bound))
+	return nil
+}
+
+// getStatic replaces the class on top of the stack with its static field or
+// method name, bound to it.
+func (vm *machine) getStatic(class *vmClass, name string, token Token) error {
+	value, method, ok := class.lookup(name)
+	if !ok {
+		return RuntimeError{token, undefined("property", name, class.staticNames(false)), nil, Value{}}
+	}
+	if method != nil {
+		value = objectValue(FunctionKind, &vmBoundMethod{vm.peek(0), method})
+	}
+	vm.pop()
+	vm.push(value)
+	return nil
+}
+
+func (vm *machine) undefinedProperty(class *vmClass, fields map[string]Value, name string, token Token) error {
+	candidates := concat(maps.Keys(fields), maps.Keys(class.methods))
+	return RuntimeError{token, undefined("property", name, candidates), nil, Value{}}
+}
+
+// captureUpvalue reuses the open upvalue for slot if a closure already
+// captured it, so closures over the same variable see each other's writes.
+func (vm *machine) captureUpvalue(slot int) *vmUpvalue {
+	var previous *vmUpvalue
+	upvalue := vm.openUpvalues
+	for upvalue != nil && upvalue.slot > slot {
+		previous = upvalue
+		upvalue = upvalue.next
+	}
+	if upvalue != nil && upvalue.slot == slot {
+		return upvalue
+	}
+	created := &vmUpvalue{slot: slot, open: true, next: upvalue}
+	if previous == nil {
+		vm.openUpvalues = created
+	} else {
+		previous.next = created
+	}
+	return created
+}
+
+func (vm *machine) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.slot >= last {
+		upvalue := vm.openUpvalues
+		upvalue.closed = vm.stack[upvalue.slot]
+		upvalue.open = false
+		vm.openUpvalues = upvalue.next
+	}
+}